@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	type DeletionScheduledResponse struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Re-confirm Password
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+	if err := auth.CheckPasswordHash(user.HashedPassword, params.Password); err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Incorrect Password.", err)
+		return
+	}
+
+	deleteAt := time.Now().UTC().Add(cfg.deletionGracePeriod)
+	if user.DeletionScheduledAt.Valid {
+		deleteAt = user.DeletionScheduledAt.Time
+	}
+
+	// Schedule deletion and sign the account out everywhere.
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error scheduling account deletion.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	err = qtx.ScheduleUserDeletion(r.Context(), database.ScheduleUserDeletionParams{
+		ID:                  userID,
+		DeletionScheduledAt: sql.NullTime{Time: deleteAt, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error scheduling account deletion.", err)
+		return
+	}
+
+	if err := qtx.RevokeAllRefreshTokensForUser(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error scheduling account deletion.", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error scheduling account deletion.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, DeletionScheduledResponse{DeletionScheduledAt: deleteAt})
+}
+
+func (cfg *apiConfig) handlerCancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	if err := cfg.DB.CancelUserDeletion(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error cancelling account deletion.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// purgeDeletedAccounts hard-deletes accounts whose grace period has ended.
+// Their chirps, tokens and exports go with them through ON DELETE CASCADE;
+// export archives on disk are removed here. It runs as a recurring job.
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context, _ struct{}) error {
+	purged, err := cfg.DB.PurgeScheduledUserDeletions(ctx, sql.NullTime{Time: time.Now().UTC(), Valid: true})
+	if err != nil {
+		return err
+	}
+	for _, userID := range purged {
+		if err := os.RemoveAll(filepath.Join(cfg.exportDir, userID.String())); err != nil {
+			log.Printf("Error removing exports for deleted user %s: %s", userID, err)
+		}
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d deleted accounts", len(purged))
+	}
+	return nil
+}
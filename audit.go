@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Audited actions.
+const (
+	auditLogin             = "login"
+	auditTokenRefresh      = "token.refresh"
+	auditTokenRevoke       = "token.revoke"
+	auditCredentialsUpdate = "credentials.update"
+	auditPasswordReset     = "password.reset"
+	auditMembershipChange  = "membership.change"
+	auditChirpUpdate       = "chirp.update"
+	auditChirpDelete       = "chirp.delete"
+	auditAdminReset        = "admin.reset"
+	auditRoleChange        = "role.change"
+	auditAccountUnlock     = "account.unlock"
+	auditWebhookReplay     = "webhook.replay"
+	// Impersonation starts when an admin is issued a token and is then
+	// recorded for every request made with it.
+	auditImpersonationStart   = "impersonation.start"
+	auditImpersonationRequest = "impersonation.request"
+)
+
+const (
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 10000
+)
+
+type auditEvent struct {
+	Action  string
+	Outcome string
+	// ActorID is who did it; uuid.Nil for a failed login or a webhook.
+	ActorID uuid.UUID
+	// UserID is the account it happened to, which users can see in their
+	// own security events; uuid.Nil if there is none.
+	UserID uuid.UUID
+	Detail string
+}
+
+// auditEmail stands in for an email address that matched no account, which
+// may be a mistyped address or even a password typed into the wrong field.
+// It keeps only the domain and a keyed, truncated hash, enough to tell
+// repeated attempts on one address apart without storing the address.
+func (cfg *apiConfig) auditEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	mac := hmac.New(sha256.New, []byte(cfg.secret))
+	mac.Write([]byte(email))
+	sum := hex.EncodeToString(mac.Sum(nil))[:12]
+
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok || domain == "" || len(domain) > 253 {
+		return sum
+	}
+	return sum + "@" + domain
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+// audit appends an event to the audit log. A failure to record it is logged
+// rather than failing the request.
+func (cfg *apiConfig) audit(r *http.Request, event auditEvent) {
+	// Whatever an admin does while impersonating is theirs, not the user's.
+	if actorID := impersonatorFromContext(r.Context()); actorID != uuid.Nil {
+		event.ActorID = actorID
+		if event.Action != auditImpersonationRequest {
+			event.Detail = strings.TrimSuffix("impersonated; "+event.Detail, "; ")
+		}
+	}
+
+	// Record it even if the client has already hung up.
+	ctx := context.WithoutCancel(r.Context())
+	err := cfg.DB.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		Action:    event.Action,
+		Outcome:   event.Outcome,
+		ActorID:   nullUUID(event.ActorID),
+		UserID:    nullUUID(event.UserID),
+		Detail:    event.Detail,
+		IpAddress: clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: requestIDFromContext(r.Context()),
+	})
+	if err != nil {
+		log.Printf("Error recording audit event %s for user %s: %s", event.Action, event.UserID, err)
+	}
+}
+
+type AuditEventResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Action    string     `json:"action"`
+	Outcome   string     `json:"outcome"`
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	UserID    *uuid.UUID `json:"user_id,omitempty"`
+	Detail    string     `json:"detail"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	RequestID string     `json:"request_id"`
+}
+
+func auditEventResponse(event database.AuditEvent) AuditEventResponse {
+	resp := AuditEventResponse{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		Action:    event.Action,
+		Outcome:   event.Outcome,
+		Detail:    event.Detail,
+		IPAddress: event.IpAddress,
+		UserAgent: event.UserAgent,
+		RequestID: event.RequestID,
+	}
+	if event.ActorID.Valid {
+		resp.ActorID = &event.ActorID.UUID
+	}
+	if event.UserID.Valid {
+		resp.UserID = &event.UserID.UUID
+	}
+	return resp
+}
+
+func auditLimit(r *http.Request) (int32, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultAuditLimit, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxAuditLimit {
+		return 0, false
+	}
+	return int32(n), true
+}
+
+// handlerListAuditEvents searches the audit log, newest first. It filters on
+// action, outcome, actor_id, user_id and an RFC 3339 since/until range, and
+// returns JSON or, with format=csv, a CSV download.
+func (cfg *apiConfig) handlerListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := database.ListAuditEventsParams{}
+
+	var ok bool
+	if params.Limit, ok = auditLimit(r); !ok {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit)+".", nil)
+		return
+	}
+	for name, dst := range map[string]*sql.NullString{"action": &params.Action, "outcome": &params.Outcome} {
+		if v := query.Get(name); v != "" {
+			*dst = sql.NullString{String: v, Valid: true}
+		}
+	}
+	for name, dst := range map[string]*uuid.NullUUID{"actor_id": &params.ActorID, "user_id": &params.UserID} {
+		if v := query.Get(name); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid "+name+".", err)
+				return
+			}
+			*dst = nullUUID(id)
+		}
+	}
+	for name, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp.", err)
+				return
+			}
+			*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+
+	events, err := cfg.DB.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving audit events.", err)
+		return
+	}
+
+	switch query.Get("format") {
+	case "", "json":
+		respondWithAuditEvents(w, events)
+	case "csv":
+		respondWithAuditCSV(w, events)
+	default:
+		respondWithError(w, http.StatusBadRequest, "format must be json or csv.", nil)
+	}
+}
+
+// handlerListSecurityEvents shows users the audit events for their own
+// account, such as sign-ins and password changes.
+func (cfg *apiConfig) handlerListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	limit, ok := auditLimit(r)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit)+".", nil)
+		return
+	}
+	events, err := cfg.DB.ListUserAuditEvents(r.Context(), database.ListUserAuditEventsParams{
+		UserID: nullUUID(caller.UserID),
+		Limit:  limit,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving security events.", err)
+		return
+	}
+
+	respondWithAuditEvents(w, events)
+}
+
+func respondWithAuditEvents(w http.ResponseWriter, events []database.AuditEvent) {
+	resp := make([]AuditEventResponse, 0, len(events))
+	for _, event := range events {
+		resp = append(resp, auditEventResponse(event))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func respondWithAuditCSV(w http.ResponseWriter, events []database.AuditEvent) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "created_at", "action", "outcome", "actor_id", "user_id", "detail", "ip_address", "user_agent", "request_id"})
+	for _, event := range events {
+		actorID, userID := "", ""
+		if event.ActorID.Valid {
+			actorID = event.ActorID.UUID.String()
+		}
+		if event.UserID.Valid {
+			userID = event.UserID.UUID.String()
+		}
+		out.Write([]string{
+			event.ID.String(),
+			event.CreatedAt.Format(time.RFC3339),
+			event.Action,
+			event.Outcome,
+			actorID,
+			userID,
+			csvSafe(event.Detail),
+			csvSafe(event.IpAddress),
+			csvSafe(event.UserAgent),
+			csvSafe(event.RequestID),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Printf("Error writing audit CSV: %s", err)
+	}
+}
+
+// csvSafe defuses values a spreadsheet would run as a formula. User agents
+// and attempted emails come straight from whoever sent the request.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
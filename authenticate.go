@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+// Scopes a personal access token or OAuth client can be granted. Tokens from
+// an interactive login carry every scope.
+const (
+	scopeChirpsWrite  = "chirps:write"
+	scopeProfileRead  = "profile:read"
+	scopeProfileWrite = "profile:write"
+)
+
+// scopeSessionOnly marks routes that personal access tokens and OAuth clients
+// may never reach, such as managing tokens, sessions, 2FA or the account
+// itself.
+const scopeSessionOnly = ""
+
+var validScopes = []string{scopeChirpsWrite, scopeProfileRead, scopeProfileWrite}
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	UserID uuid.UUID
+	// SessionID and Role are set for access tokens issued by a login.
+	SessionID uuid.UUID
+	Role      string
+	// TokenID is set for personal access tokens.
+	TokenID uuid.UUID
+	// ClientID and GrantID are set for tokens issued to an OAuth client.
+	ClientID string
+	GrantID  uuid.UUID
+	// ActorID is set for impersonation tokens: the admin acting as UserID.
+	ActorID uuid.UUID
+	// Scopes is nil for session tokens, which may do anything.
+	Scopes []string
+}
+
+func (p principal) hasScope(scope string) bool {
+	if p.TokenID == uuid.Nil && p.ClientID == "" && p.ActorID == uuid.Nil {
+		return true
+	}
+	if scope == scopeSessionOnly {
+		return false
+	}
+	return slices.Contains(p.Scopes, scope)
+}
+
+type authError struct {
+	code int
+	msg  string
+	err  error
+}
+
+func (e *authError) Error() string {
+	if e.err != nil {
+		return e.msg + ": " + e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// authenticate resolves the bearer token on r, which may be an access token
+// JWT (from a login or an OAuth client) or a personal access token, and
+// checks that it grants scope.
+func (cfg *apiConfig) authenticate(r *http.Request, scope string) (principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return principal{}, &authError{http.StatusUnauthorized, "Error retreiving access_token.", err}
+	}
+
+	var p principal
+	if auth.IsPersonalAccessToken(token) {
+		pat, err := cfg.DB.GetPersonalAccessTokenByHash(r.Context(), auth.HashToken(token))
+		if err != nil {
+			return principal{}, &authError{http.StatusUnauthorized, "Error validating access_token.", err}
+		}
+		if pat.RevokedAt.Valid || pat.ExpiresAt.Before(time.Now().UTC()) {
+			return principal{}, &authError{http.StatusUnauthorized, "Access token is expired or has been revoked.", nil}
+		}
+		if err := cfg.DB.TouchPersonalAccessToken(r.Context(), pat.ID); err != nil {
+			log.Printf("Error recording use of personal access token %s: %s", pat.ID, err)
+		}
+		p = principal{UserID: pat.UserID, TokenID: pat.ID, Scopes: pat.Scopes}
+	} else {
+		claims, err := cfg.jwtKeys.ValidateAccessToken(token)
+		if err != nil {
+			return principal{}, &authError{http.StatusUnauthorized, "Error validating access_token.", err}
+		}
+		p = principal{UserID: claims.UserID, SessionID: claims.SessionID, Role: claims.Role}
+
+		// OAuth tokens are only good while the user's grant to the client
+		// stands; sid names the grant.
+		if claims.ClientID != "" {
+			grant, err := cfg.DB.GetOAuthGrant(r.Context(), claims.SessionID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return principal{}, &authError{http.StatusInternalServerError, "Error validating access_token.", err}
+			}
+			if err != nil || grant.RevokedAt.Valid {
+				return principal{}, &authError{http.StatusUnauthorized, "Access token has been revoked.", err}
+			}
+			p = principal{UserID: claims.UserID, ClientID: claims.ClientID, GrantID: grant.ID, Scopes: claims.Scopes}
+		}
+		if claims.ActorID != uuid.Nil {
+			p = principal{UserID: claims.UserID, ActorID: claims.ActorID, Scopes: impersonationScopes}
+		}
+	}
+
+	if !p.hasScope(scope) {
+		msg := "Access token is missing the " + scope + " scope."
+		if p.ActorID != uuid.Nil {
+			msg = "Impersonation tokens can't use this endpoint."
+		} else if scope == scopeSessionOnly {
+			msg = "Personal access tokens and OAuth clients can't use this endpoint."
+		}
+		return principal{}, &authError{http.StatusForbidden, msg, nil}
+	}
+	return p, nil
+}
+
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		respondWithError(w, authErr.code, authErr.msg, authErr.err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "Unauthorized.", err)
+}
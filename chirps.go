@@ -3,12 +3,10 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-	"os"
 	"sort"
 	"strings"
 	"time"
 
-	"github.com/bdjekel/chirpy/internal/auth"
 	"github.com/bdjekel/chirpy/internal/database"
 	"github.com/google/uuid"
 )
@@ -35,34 +33,62 @@ func (cfg *apiConfig) handlerChirps(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ValidateJWT
-	access_token, err := auth.GetBearerToken(r.Header)
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error retreiving access_token.", err)
+		respondWithAuthError(w, err)
 		return
 	}
+	userID := caller.UserID
 
-	userID, err := auth.ValidateJWT(access_token, os.Getenv("SECRET"))
+	if !cfg.requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	_, ent, err := cfg.userEntitlements(r.Context(), userID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating access_token.", err)
+		respondWithEntitlementsError(w, err)
 		return
 	}
 
 	// Handle too long chrip
-	const maxChirpLength = 140
-	if len(params.Body) > maxChirpLength {
+	if len(params.Body) > ent.MaxChirpLength {
 		respondWithError(w, http.StatusBadRequest, "Max Chirp length exceeded.", nil)
 		return
 	}
 
+	limited, err := cfg.chirpRateLimited(r.Context(), userID, ent)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking chirp rate limit.", err)
+		return
+	}
+	if limited {
+		respondWithError(w, http.StatusTooManyRequests, "Hourly chirp limit reached.", nil)
+		return
+	}
+
 	// Handle Profanity
 	params.Body = profaneWordHandler(params.Body)
 
-	// Add chirp to database
-	chirp, err := cfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
+	// Add chirp to database, and to the outbox in the same transaction
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
 		Body: params.Body,
 		UserID: userID,
 	})
+	if err == nil {
+		err = enqueueOutbox(r.Context(), qtx, aggregateChirp, chirp.ID.String(), webhookChirpCreated, chirpEvent{Chirp: chirp})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating chirp", err)
 		return
@@ -125,6 +151,77 @@ func (cfg *apiConfig) handlerGetChirpByID(w http.ResponseWriter, r *http.Request
 	respondWithJSON(w, http.StatusOK, chirp)
 }
 
+// handlerUpdateChirp lets authors fix a chirp shortly after posting it, if
+// their tier has an edit window.
+func (cfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID.", err)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	if !cfg.requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp does not exist.", err)
+		return
+	}
+	if chirp.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can only edit your own chirps.", nil)
+		return
+	}
+
+	_, ent, err := cfg.userEntitlements(r.Context(), userID)
+	if err != nil {
+		respondWithEntitlementsError(w, err)
+		return
+	}
+	if !ent.CanEdit(chirp.CreatedAt, time.Now()) {
+		respondWithError(w, http.StatusForbidden, "This chirp can no longer be edited.", nil)
+		return
+	}
+	if len(params.Body) > ent.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, "Max Chirp length exceeded.", nil)
+		return
+	}
+
+	chirp, err = cfg.DB.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirpID,
+		Body: profaneWordHandler(params.Body),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating chirp", err)
+		return
+	}
+
+	cfg.audit(r, auditEvent{Action: auditChirpUpdate, Outcome: auditSuccess, ActorID: userID, UserID: userID, Detail: "chirp " + chirpID.String()})
+	respondWithJSON(w, http.StatusOK, chirp)
+}
+
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
 	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
     if err != nil {
@@ -132,18 +229,13 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
         return
     }
 
-	// Validate JWT Access Token
-	access_token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error retreiving access_token.", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(access_token, os.Getenv("SECRET"))
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, http.StatusForbidden, "Error validating access_token.", err)
+		respondWithAuthError(w, err)
 		return
 	}
+	userID := caller.UserID
 
 	// Find Chirp in Database
 	chirp_data, err := cfg.DB.GetChirpByID(r.Context(), chirpID)
@@ -152,15 +244,32 @@ func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if chirp_data.UserID != userID {
+	// Moderators can take down anyone's chirps.
+	if chirp_data.UserID != userID && !hasPermission(caller.Role, permDeleteAnyChirp) {
 		respondWithError(w, http.StatusForbidden, "Unauthorized DELETE Request.", err)
 		return
 	}
 
-	if err := cfg.DB.DeleteChirp(r.Context(), chirpID); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating user", err)
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting chirp", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	err = qtx.DeleteChirp(r.Context(), chirpID)
+	if err == nil {
+		err = enqueueOutbox(r.Context(), qtx, aggregateChirp, chirpID.String(), webhookChirpDeleted, chirpEvent{Chirp: chirp_data})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting chirp", err)
 		return
 	}
+	cfg.audit(r, auditEvent{Action: auditChirpDelete, Outcome: auditSuccess, ActorID: userID, UserID: chirp_data.UserID, Detail: "chirp " + chirpID.String()})
 
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/bdjekel/chirpy/internal/config"
+	"github.com/bdjekel/chirpy/internal/database"
+)
+
+const usage = `usage:
+  chirpy [flags]                         start the server
+  chirpy [flags] config                  print the configuration, secrets redacted
+  chirpy [flags] set-role EMAIL ROLE     set a user's role (user, moderator or admin)`
+
+// runCommand runs a one-off command instead of the server. set-role is how
+// the first admin is made, since only admins can grant roles over the API.
+func runCommand(ctx context.Context, conf *config.Config, db *database.Queries, args []string) error {
+	switch args[0] {
+	case "config":
+		if len(args) != 1 {
+			return errors.New(usage)
+		}
+		fmt.Print(conf)
+		return nil
+	case "set-role":
+		if len(args) != 3 {
+			return errors.New(usage)
+		}
+		email, role := args[1], args[2]
+		if !slices.Contains(validRoles, role) {
+			return fmt.Errorf("unknown role %q; must be one of user, moderator or admin", role)
+		}
+		updated, err := db.SetUserRoleByEmail(ctx, database.SetUserRoleByEmailParams{Email: email, Role: role})
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return fmt.Errorf("no user with email %s", email)
+		}
+		fmt.Printf("%s is now %s. The role applies from their next login or token refresh.\n", email, role)
+		return nil
+	}
+	return errors.New(usage)
+}
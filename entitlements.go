@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/entitlements"
+	"github.com/google/uuid"
+)
+
+var errEntitlementsUserNotFound = errors.New("user does not exist")
+
+// userEntitlements returns the tier a user is on and what it lets them do.
+func (cfg *apiConfig) userEntitlements(ctx context.Context, userID uuid.UUID) (string, entitlements.Entitlements, error) {
+	user, err := cfg.DB.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", entitlements.Entitlements{}, errEntitlementsUserNotFound
+	}
+	if err != nil {
+		return "", entitlements.Entitlements{}, err
+	}
+	tier := entitlements.TierFor(user.IsChirpyRed)
+	return tier, cfg.entitlements.For(tier), nil
+}
+
+// respondWithEntitlementsError reports a failure of userEntitlements.
+func respondWithEntitlementsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errEntitlementsUserNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "User does not exist.", err)
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, "Error retrieving entitlements.", err)
+}
+
+// handlerGetEntitlements tells clients which limits and features apply to
+// the caller, so they don't have to know what each tier includes.
+func (cfg *apiConfig) handlerGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	type EntitlementsResponse struct {
+		Tier string `json:"tier"`
+		entitlements.Entitlements
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeProfileRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	tier, ent, err := cfg.userEntitlements(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithEntitlementsError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EntitlementsResponse{Tier: tier, Entitlements: ent})
+}
+
+// chirpRateLimited reports whether posting another chirp would take userID
+// over their tier's hourly limit.
+func (cfg *apiConfig) chirpRateLimited(ctx context.Context, userID uuid.UUID, ent entitlements.Entitlements) (bool, error) {
+	if ent.ChirpsPerHour == 0 {
+		return false, nil
+	}
+	count, err := cfg.DB.CountUserChirpsSince(ctx, database.CountUserChirpsSinceParams{
+		UserID:    userID,
+		CreatedAt: time.Now().UTC().Add(-time.Hour),
+	})
+	if err != nil {
+		return false, err
+	}
+	return count >= int64(ent.ChirpsPerHour), nil
+}
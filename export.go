@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const dataExportExpiry = 7 * 24 * time.Hour
+
+type DataExportResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func dataExportResponse(export database.DataExport) DataExportResponse {
+	resp := DataExportResponse{
+		ID:        export.ID,
+		CreatedAt: export.CreatedAt,
+		Status:    export.Status,
+	}
+	if export.ExpiresAt.Valid {
+		resp.ExpiresAt = &export.ExpiresAt.Time
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerRequestDataExport(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating data export.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	export, err := qtx.CreateDataExport(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating data export.", err)
+		return
+	}
+
+	// Build the archive off the request path; the user is emailed when done.
+	_, err = buildDataExportJob.Enqueue(r.Context(), jobStore{db: qtx}, dataExportJob{ExportID: export.ID})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating data export.", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating data export.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, dataExportResponse(export))
+}
+
+func (cfg *apiConfig) handlerGetDataExport(w http.ResponseWriter, r *http.Request) {
+	exportID, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid export ID.", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	export, err := cfg.DB.GetDataExport(r.Context(), exportID)
+	if err != nil || export.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Export does not exist.", err)
+		return
+	}
+
+	if export.Status != "ready" {
+		respondWithJSON(w, http.StatusOK, dataExportResponse(export))
+		return
+	}
+	if !export.FilePath.Valid || (export.ExpiresAt.Valid && export.ExpiresAt.Time.Before(time.Now().UTC())) {
+		respondWithError(w, http.StatusGone, "Export has expired.", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, export.ID))
+	http.ServeFile(w, r, export.FilePath.String)
+}
+
+// buildDataExport writes the archive for a pending export and emails the
+// user that it is ready. If the email fails, a retry only resends it.
+func (cfg *apiConfig) buildDataExport(ctx context.Context, job dataExportJob) error {
+	export, err := cfg.DB.GetDataExport(ctx, job.ExportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The account, and its exports, have been deleted since.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch export.Status {
+	case "pending":
+		path, err := cfg.writeDataExport(ctx, export)
+		if err != nil {
+			log.Printf("Error building data export %s: %s", export.ID, err)
+			return cfg.DB.FailDataExport(ctx, database.FailDataExportParams{
+				ID:    export.ID,
+				Error: sql.NullString{String: err.Error(), Valid: true},
+			})
+		}
+
+		err = cfg.DB.CompleteDataExport(ctx, database.CompleteDataExportParams{
+			ID:        export.ID,
+			FilePath:  sql.NullString{String: path, Valid: true},
+			ExpiresAt: sql.NullTime{Time: time.Now().UTC().Add(dataExportExpiry), Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	case "ready":
+	default:
+		return nil
+	}
+
+	user, err := cfg.DB.GetUserByID(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		From:    cfg.mailFrom,
+		To:      []string{user.Email},
+		Subject: "Your Chirpy data export is ready",
+		Body: fmt.Sprintf(
+			"Your data export is ready. Download it from GET /api/users/me/exports/%s within %s.\n",
+			export.ID, dataExportExpiry),
+	})
+}
+
+// writeDataExport gathers everything stored about the user into a ZIP archive
+// under exportDir/<user id>/ and returns its path.
+func (cfg *apiConfig) writeDataExport(ctx context.Context, export database.DataExport) (string, error) {
+	type profileExport struct {
+		ID              uuid.UUID  `json:"id"`
+		CreatedAt       time.Time  `json:"created_at"`
+		UpdatedAt       time.Time  `json:"updated_at"`
+		Email           string     `json:"email"`
+		IsChirpyRed     bool       `json:"is_chirpy_red"`
+		EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	}
+
+	type sessionExport struct {
+		SessionID  uuid.UUID  `json:"session_id"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt time.Time  `json:"last_used_at"`
+		ExpiresAt  time.Time  `json:"expires_at"`
+		RevokedAt  *time.Time `json:"revoked_at"`
+		UserAgent  string     `json:"user_agent"`
+		IPAddress  string     `json:"ip_address"`
+	}
+
+	user, err := cfg.DB.GetUserByID(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+	chirps, err := cfg.DB.GetChirpsByAuthor(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+	tokens, err := cfg.DB.ListRefreshTokensForUser(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	profile := profileExport{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+	}
+	if user.EmailVerifiedAt.Valid {
+		profile.EmailVerifiedAt = &user.EmailVerifiedAt.Time
+	}
+
+	// Token values are credentials, so only their metadata is exported.
+	sessions := make([]sessionExport, 0, len(tokens))
+	for _, t := range tokens {
+		s := sessionExport{
+			SessionID:  t.FamilyID,
+			CreatedAt:  t.CreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt:  t.ExpiresAt,
+			UserAgent:  t.UserAgent,
+			IPAddress:  t.IpAddress,
+		}
+		if t.RevokedAt.Valid {
+			s.RevokedAt = &t.RevokedAt.Time
+		}
+		sessions = append(sessions, s)
+	}
+
+	dir := filepath.Join(cfg.exportDir, export.UserID.String())
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, export.ID.String()+".zip")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"chirps.json", chirps},
+		{"sessions.json", sessions},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return "", err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	return path, f.Close()
+}
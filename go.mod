@@ -10,3 +10,9 @@ require (
 )
 
 require github.com/golang-jwt/jwt/v5 v5.2.2
+
+require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require github.com/BurntSushi/toml v1.4.0
+
+require golang.org/x/sys v0.32.0 // indirect
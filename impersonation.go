@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	impersonationExpiry = 15 * time.Minute
+	// impersonatedByHeader is set on every response to a request made with
+	// an impersonation token, naming the admin behind it.
+	impersonatedByHeader = "X-Impersonated-By"
+)
+
+// impersonationScopes is all an impersonation token may do: enough to
+// reproduce what the user sees, but nothing that changes their credentials,
+// sessions, tokens or account.
+var impersonationScopes = []string{scopeChirpsWrite, scopeProfileRead}
+
+type impersonatorContextKey struct{}
+
+func impersonatorFromContext(ctx context.Context) uuid.UUID {
+	id, _ := ctx.Value(impersonatorContextKey{}).(uuid.UUID)
+	return id
+}
+
+// middlewareImpersonation marks responses to impersonated requests and
+// records each such request in the audit log. It doesn't authenticate
+// anything; handlers still do that.
+func (cfg *apiConfig) middlewareImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil || auth.IsPersonalAccessToken(token) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		claims, err := cfg.jwtKeys.ValidateAccessToken(token)
+		if err != nil || claims.ActorID == uuid.Nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(impersonatedByHeader, claims.ActorID.String())
+		r = r.WithContext(context.WithValue(r.Context(), impersonatorContextKey{}, claims.ActorID))
+		cfg.audit(r, auditEvent{
+			Action:  auditImpersonationRequest,
+			Outcome: auditSuccess,
+			UserID:  claims.UserID,
+			Detail:  r.Method + " " + r.URL.Path,
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlerImpersonateUser gives an admin a short-lived access token for
+// another user's account, for reproducing problems they report. A reason is
+// required and goes in the audit log.
+func (cfg *apiConfig) handlerImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+	type ImpersonationResponse struct {
+		UserID    uuid.UUID `json:"user_id"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID.", err)
+		return
+	}
+	caller, _ := principalFromContext(r.Context())
+	if userID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't impersonate yourself.", nil)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" {
+		respondWithError(w, http.StatusBadRequest, "A reason is required.", nil)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+
+	token, err := cfg.jwtKeys.MakeImpersonationJWT(user.ID, caller.UserID, impersonationExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating access_token.", err)
+		return
+	}
+	cfg.audit(r, auditEvent{
+		Action:  auditImpersonationStart,
+		Outcome: auditSuccess,
+		ActorID: caller.UserID,
+		UserID:  user.ID,
+		Detail:  params.Reason,
+	})
+
+	respondWithJSON(w, http.StatusCreated, ImpersonationResponse{
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().UTC().Add(impersonationExpiry),
+	})
+}
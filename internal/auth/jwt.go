@@ -12,43 +12,107 @@ import (
 	"github.com/google/uuid"
 )
 
+// AccessClaims is what an access token says about its bearer.
+type AccessClaims struct {
+	UserID uuid.UUID
+	// SessionID is the refresh token family the access token was issued
+	// from, or uuid.Nil for tokens not tied to a session. For OAuth tokens it
+	// is the grant the token was issued under.
+	SessionID uuid.UUID
+	// Role is the user's role when the token was issued, for tokens from a
+	// login.
+	Role string
+	// ClientID and Scopes are set on tokens issued to an OAuth client.
+	ClientID string
+	Scopes   []string
+	// ActorID is set on impersonation tokens: the admin acting as UserID.
+	ActorID   uuid.UUID
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type accessTokenClaims struct {
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	// Actor is the act claim of RFC 8693 section 4.1.
+	Actor *actorClaim `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+type actorClaim struct {
+	Subject string `json:"sub"`
+}
+
 func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer: "chirpy",
-		IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject: userID.String(),
-	})
+	return MakeSessionJWT(userID, uuid.Nil, tokenSecret, expiresIn)
+}
+
+// MakeSessionJWT is MakeJWT with a sid claim naming the session (refresh
+// token family) the access token belongs to.
+func MakeSessionJWT(userID, sessionID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "chirpy",
+			IssuedAt: jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject: userID.String(),
+		},
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	
 	return token.SignedString([]byte(tokenSecret))
 }
 
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := ValidateAccessToken(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+func ValidateAccessToken(tokenString, tokenSecret string) (AccessClaims, error) {
 	
-	claims := jwt.RegisteredClaims{}
-//TODO: Read more in-depth on keyfunc argument below. Had to copy pasta from boot.dev's solution file.
+	claims := accessTokenClaims{}
+	// The keyfunc hands back the shared secret, so the parser must be told to
+	// accept HS256 only; otherwise the token picks its own algorithm.
 	keyFunc := func(token *jwt.Token) (interface{}, error) { 
 		return []byte(tokenSecret), nil }
 
 	token, err := jwt.ParseWithClaims(
 		tokenString, 
 		&claims, 
-		keyFunc)
+		keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer("chirpy"))
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
 	}
 	
 	userID, err := uuid.Parse(userIDString)
 	if err != nil {
-		return uuid.Nil, err
+		return AccessClaims{}, err
+	}
+
+	sessionID := uuid.Nil
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return AccessClaims{}, err
+		}
 	}
 
-	return userID, nil
+	return AccessClaims{UserID: userID, SessionID: sessionID}, nil
 }
 
 
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	jwtlib "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -218,4 +219,50 @@ func TestGetBearerTokenNoBearerPrefix(t *testing.T) {
 		t.Error("incorrect error message")
 	}
 	t.Errorf("error not thrown. should be missing bearer prefix. See bearerToken below:\n>>%s<<\n", bearerToken)
-}
\ No newline at end of file
+}
+
+func TestSessionJWTCarriesSessionID(t *testing.T) {
+	userID, sessionID := uuid.New(), uuid.New()
+	secretToken := "ThIsIsAsEcReTtOkEn9876"
+
+	jwt, err := MakeSessionJWT(userID, sessionID, secretToken, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating jwt: %s\n", err)
+	}
+
+	claims, err := ValidateAccessToken(jwt, secretToken)
+	if err != nil {
+		t.Fatalf("Error validating jwt: %s\n", err)
+	}
+	if claims.UserID != userID || claims.SessionID != sessionID {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	plain, err := MakeJWT(userID, secretToken, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating jwt: %s\n", err)
+	}
+	claims, err = ValidateAccessToken(plain, secretToken)
+	if err != nil || claims.SessionID != uuid.Nil {
+		t.Errorf("expected no session for plain jwt, got %+v (%v)", claims, err)
+	}
+}
+
+func TestValidateJWTRejectsOtherAlgorithms(t *testing.T) {
+	userID := uuid.New()
+	secretToken := "ThIsIsAsEcReTtOkEn9876"
+
+	token := jwtlib.NewWithClaims(jwtlib.SigningMethodNone, jwtlib.RegisteredClaims{
+		Issuer:    "chirpy",
+		Subject:   userID.String(),
+		ExpiresAt: jwtlib.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	unsigned, err := token.SignedString(jwtlib.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("Error creating unsigned jwt: %s", err)
+	}
+
+	if _, err := ValidateJWT(unsigned, secretToken); err == nil {
+		t.Error("unsigned jwt was accepted")
+	}
+}
@@ -0,0 +1,447 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const minRSAKeyBits = 2048
+
+// KeySet signs and verifies access tokens with asymmetric keys. One key is
+// used for signing; every key in the set (including retired ones whose
+// private half is gone) is accepted for verification, selected by the token's
+// kid header. Only EdDSA (Ed25519) and RS256 are accepted.
+type KeySet struct {
+	issuer   string
+	audience string
+
+	mu           sync.RWMutex
+	signingKeyID string
+	keys         map[string]*keySetKey
+}
+
+type keySetKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+func NewKeySet(issuer, audience string) *KeySet {
+	return &KeySet{
+		issuer:   issuer,
+		audience: audience,
+		keys:     map[string]*keySetKey{},
+	}
+}
+
+func (ks *KeySet) Issuer() string {
+	return ks.issuer
+}
+
+func (ks *KeySet) Audience() string {
+	return ks.audience
+}
+
+// AddKey adds a key under kid. Private keys (ed25519.PrivateKey,
+// *rsa.PrivateKey) can sign and verify; public keys (ed25519.PublicKey,
+// *rsa.PublicKey) only verify.
+func (ks *KeySet) AddKey(kid string, key interface{}) error {
+	if kid == "" {
+		return errors.New("key id must not be empty")
+	}
+
+	k := &keySetKey{id: kid}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		k.method, k.private, k.public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, key
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA key %q is %d bits, need at least %d", kid, key.N.BitLen(), minRSAKeyBits)
+		}
+		k.method, k.private, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA key %q is %d bits, need at least %d", kid, key.N.BitLen(), minRSAKeyBits)
+		}
+		k.method, k.public = jwt.SigningMethodRS256, key
+	default:
+		return fmt.Errorf("unsupported key type %T for %q", key, kid)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[kid] = k
+	return nil
+}
+
+// SetSigningKey selects which key signs new tokens. It must hold a private key.
+func (ks *KeySet) SetSigningKey(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k, ok := ks.keys[kid]
+	if !ok {
+		return fmt.Errorf("unknown key %q", kid)
+	}
+	if k.private == nil {
+		return fmt.Errorf("key %q has no private key", kid)
+	}
+	ks.signingKeyID = kid
+	return nil
+}
+
+// RemoveKey drops a key once no unexpired tokens signed by it remain.
+func (ks *KeySet) RemoveKey(kid string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.keys, kid)
+	if ks.signingKeyID == kid {
+		ks.signingKeyID = ""
+	}
+}
+
+func (ks *KeySet) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return ks.MakeSessionJWT(userID, uuid.Nil, "", expiresIn)
+}
+
+// MakeSessionJWT signs an access token for a login session. role, if set, is
+// carried in a role claim for authorization checks; a role change takes
+// effect when the token is next refreshed.
+func (ks *KeySet) MakeSessionJWT(userID, sessionID uuid.UUID, role string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := accessTokenClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.issuer,
+			Audience:  jwt.ClaimStrings{ks.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+
+	return ks.Sign(claims)
+}
+
+// MakeClientJWT is MakeSessionJWT for a token issued to an OAuth client:
+// the sid claim names the grant, and client_id and scope (space separated, as
+// in RFC 9068) say who may use it for what.
+func (ks *KeySet) MakeClientJWT(userID uuid.UUID, clientID string, scopes []string, grantID uuid.UUID, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := accessTokenClaims{
+		SessionID: grantID.String(),
+		ClientID:  clientID,
+		Scope:     strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.issuer,
+			Audience:  jwt.ClaimStrings{ks.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+
+	return ks.Sign(claims)
+}
+
+// MakeImpersonationJWT signs an access token that lets actorID act as
+// userID. The act claim names the actor, so the token can be told apart from
+// the user's own and kept away from what only the user may do.
+func (ks *KeySet) MakeImpersonationJWT(userID, actorID uuid.UUID, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	claims := accessTokenClaims{
+		Actor: &actorClaim{Subject: actorID.String()},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.issuer,
+			Audience:  jwt.ClaimStrings{ks.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+
+	return ks.Sign(claims)
+}
+
+// Sign signs arbitrary claims with the current signing key, setting kid.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	ks.mu.RLock()
+	k, ok := ks.keys[ks.signingKeyID]
+	ks.mu.RUnlock()
+	if !ok {
+		return "", errors.New("key set has no signing key")
+	}
+
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.id
+	return token.SignedString(k.private)
+}
+
+func (ks *KeySet) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := ks.ValidateAccessToken(tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+func (ks *KeySet) ValidateAccessToken(tokenString string) (AccessClaims, error) {
+	claims := accessTokenClaims{}
+	if err := ks.Parse(tokenString, &claims); err != nil {
+		return AccessClaims{}, err
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	sessionID := uuid.Nil
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return AccessClaims{}, err
+		}
+	}
+
+	ac := AccessClaims{UserID: userID, SessionID: sessionID, Role: claims.Role, ClientID: claims.ClientID}
+	if claims.ClientID != "" {
+		ac.Scopes = strings.Fields(claims.Scope)
+	}
+	if claims.Actor != nil {
+		ac.ActorID, err = uuid.Parse(claims.Actor.Subject)
+		if err != nil {
+			return AccessClaims{}, err
+		}
+	}
+	if claims.IssuedAt != nil {
+		ac.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		ac.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return ac, nil
+}
+
+// Parse verifies tokenString into claims: the kid must name a key in the set,
+// the alg must be the one that key is for, and issuer, audience and expiry
+// must all check out.
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) error {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+
+		ks.mu.RLock()
+		k, ok := ks.keys[kid]
+		ks.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != k.method.Alg() {
+			return nil, fmt.Errorf("alg %q does not match key %q", token.Method.Alg(), kid)
+		}
+		return k.public, nil
+	}
+
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(ks.issuer),
+		jwt.WithAudience(ks.audience),
+		jwt.WithExpirationRequired(),
+	)
+	return err
+}
+
+// JWK is a public key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key in the set.
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
+
+// PublicKey decodes a published key: RSA, EC (P-256, P-384, P-521) or OKP
+// Ed25519. It is how tokens from other issuers, such as OIDC providers, are
+// verified.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := func(name, v string) ([]byte, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("jwk %q: bad %s", k.KeyID, name)
+		}
+		return b, nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, fmt.Errorf("jwk %q: exponent too large", k.KeyID)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("jwk %q: RSA keys must be at least %d bits", k.KeyID, minRSAKeyBits)
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// ECDH rejects points that aren't on the curve.
+		if _, err := pub.ECDH(); err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.KeyID, err)
+		}
+		return pub, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("jwk %q: unsupported curve %q", k.KeyID, k.Curve)
+		}
+		x, err := decode("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("jwk %q: bad Ed25519 key", k.KeyID)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("jwk %q: unsupported key type %q", k.KeyID, k.KeyType)
+}
+
+// ParseKeyPEM reads a PKCS#8 private key, a PKCS#1 RSA private key or a PKIX
+// public key.
+func ParseKeyPEM(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+}
+
+// LoadKeySet builds a KeySet from every *.pem file in dir, using each file's
+// base name as its kid, and signs with signingKeyID.
+func LoadKeySet(dir, signingKeyID, issuer, audience string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys found in %s", dir)
+	}
+
+	ks := NewKeySet(issuer, audience)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if err := ks.AddKey(kid, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ks.SetSigningKey(signingKeyID); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newTestKeySet(t *testing.T) *KeySet {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	ks := NewKeySet("chirpy", "chirpy-api")
+	if err := ks.AddKey("ed-1", priv); err != nil {
+		t.Fatalf("Error adding key: %s", err)
+	}
+	if err := ks.SetSigningKey("ed-1"); err != nil {
+		t.Fatalf("Error setting signing key: %s", err)
+	}
+	return ks
+}
+
+func TestKeySetRoundTripEd25519AndRS256(t *testing.T) {
+	ks := newTestKeySet(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	if err := ks.AddKey("rsa-1", rsaKey); err != nil {
+		t.Fatalf("Error adding key: %s", err)
+	}
+
+	userID, sessionID := uuid.New(), uuid.New()
+	for _, kid := range []string{"ed-1", "rsa-1"} {
+		if err := ks.SetSigningKey(kid); err != nil {
+			t.Fatalf("Error setting signing key: %s", err)
+		}
+		token, err := ks.MakeSessionJWT(userID, sessionID, "admin", time.Minute)
+		if err != nil {
+			t.Fatalf("Error creating jwt with %s: %s", kid, err)
+		}
+		claims, err := ks.ValidateAccessToken(token)
+		if err != nil {
+			t.Fatalf("Error validating jwt with %s: %s", kid, err)
+		}
+		if claims.UserID != userID || claims.SessionID != sessionID || claims.Role != "admin" {
+			t.Errorf("unexpected claims with %s: %+v", kid, claims)
+		}
+	}
+}
+
+func TestImpersonationJWTCarriesActor(t *testing.T) {
+	ks := newTestKeySet(t)
+	userID, adminID := uuid.New(), uuid.New()
+
+	token, err := ks.MakeImpersonationJWT(userID, adminID, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating jwt: %s", err)
+	}
+	claims, err := ks.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatalf("Error validating jwt: %s", err)
+	}
+	if claims.UserID != userID || claims.ActorID != adminID || claims.Role != "" || claims.SessionID != uuid.Nil {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	own, _ := ks.MakeSessionJWT(userID, uuid.New(), "user", time.Minute)
+	if claims, _ := ks.ValidateAccessToken(own); claims.ActorID != uuid.Nil {
+		t.Errorf("expected no actor on a login token, got %s", claims.ActorID)
+	}
+}
+
+func TestKeySetRotationKeepsOldTokensValid(t *testing.T) {
+	ks := newTestKeySet(t)
+	userID := uuid.New()
+
+	oldToken, err := ks.MakeJWT(userID, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating jwt: %s", err)
+	}
+
+	_, next, _ := ed25519.GenerateKey(rand.Reader)
+	ks.AddKey("ed-2", next)
+	ks.SetSigningKey("ed-2")
+
+	if _, err := ks.ValidateJWT(oldToken); err != nil {
+		t.Errorf("token from previous key rejected during rotation: %s", err)
+	}
+
+	ks.RemoveKey("ed-1")
+	if _, err := ks.ValidateJWT(oldToken); err == nil {
+		t.Error("token from removed key was accepted")
+	}
+}
+
+func TestKeySetRejectsForeignTokens(t *testing.T) {
+	ks := newTestKeySet(t)
+	userID := uuid.New()
+
+	// HS256 with the public key as the "secret" is the classic alg confusion.
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{"chirpy-api"},
+		Subject:   userID.String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	hs.Header["kid"] = "ed-1"
+	pub := ks.keys["ed-1"].public.(ed25519.PublicKey)
+	forged, _ := hs.SignedString([]byte(pub))
+	if _, err := ks.ValidateJWT(forged); err == nil {
+		t.Error("HS256 token was accepted")
+	}
+
+	legacy, _ := MakeJWT(userID, "ThIsIsAsEcReTtOkEn9876", time.Minute)
+	if _, err := ks.ValidateJWT(legacy); err == nil {
+		t.Error("shared-secret token was accepted")
+	}
+
+	other := NewKeySet("chirpy", "someone-else")
+	other.AddKey("ed-1", ks.keys["ed-1"].private)
+	other.SetSigningKey("ed-1")
+	wrongAudience, _ := other.MakeJWT(userID, time.Minute)
+	if _, err := ks.ValidateJWT(wrongAudience); err == nil {
+		t.Error("token for another audience was accepted")
+	}
+}
+
+func TestLoadKeySetAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	os.WriteFile(filepath.Join(dir, "current.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+
+	oldPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	pubDER, _ := x509.MarshalPKIXPublicKey(oldPub)
+	os.WriteFile(filepath.Join(dir, "retired.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600)
+
+	ks, err := LoadKeySet(dir, "current", "chirpy", "chirpy-api")
+	if err != nil {
+		t.Fatalf("LoadKeySet returned error: %s", err)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "current" || jwks.Keys[0].Curve != "Ed25519" {
+		t.Fatalf("unexpected JWKS: %+v", jwks)
+	}
+	if jwks.Keys[0].X == "" || len(pub) != ed25519.PublicKeySize {
+		t.Error("JWKS missing public key material")
+	}
+
+	if _, err := LoadKeySet(dir, "retired", "chirpy", "chirpy-api"); err == nil {
+		t.Error("expected a public-only key to be refused for signing")
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	size := 32
+	x, y := make([]byte, size), make([]byte, size)
+	ecKey.X.FillBytes(x)
+	ecKey.Y.FillBytes(y)
+	jwk := JWK{KeyType: "EC", KeyID: "ec-1", Curve: "P-256",
+		X: base64.RawURLEncoding.EncodeToString(x), Y: base64.RawURLEncoding.EncodeToString(y)}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey returned error: %s", err)
+	}
+	if !ecKey.PublicKey.Equal(pub) {
+		t.Error("decoded EC key doesn't match")
+	}
+
+	// A point off the curve must be refused.
+	y[size-1] ^= 1
+	jwk.Y = base64.RawURLEncoding.EncodeToString(y)
+	if _, err := jwk.PublicKey(); err == nil {
+		t.Error("expected an off-curve point to be rejected")
+	}
+
+	// Round trip through this set's own JWKS.
+	ks := newTestKeySet(t)
+	published := ks.JWKS().Keys[0]
+	if _, err := published.PublicKey(); err != nil {
+		t.Errorf("couldn't decode own JWKS entry: %s", err)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const mfaChallengeAudience = "chirpy-mfa-challenge"
+
+// MakeMFAChallengeToken issues the short-lived token handed out after a
+// correct password for an account with 2FA. It only proves the first factor
+// and is signed with its own key, so it can't be used as an access token.
+func MakeMFAChallengeToken(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+
+	return token.SignedString(purposeKey(tokenSecret, mfaChallengeAudience))
+}
+
+func ValidateMFAChallengeToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return purposeKey(tokenSecret, mfaChallengeAudience), nil
+	}
+
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(mfaChallengeAudience),
+		jwt.WithIssuer("chirpy"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(claims.Subject)
+}
@@ -1,21 +1,139 @@
 package auth
 
 import (
-	"log"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrPasswordMismatch is returned by CheckPasswordHash when the password is
+// wrong.
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Argon2Params are the Argon2id cost parameters new password hashes are made
+// with. Existing hashes carry their own parameters, so changing these only
+// affects hashes made (or remade, see NeedsRehash) afterwards.
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP Password Storage Cheat Sheet's
+// first Argon2id configuration.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// HashPassword hashes a password with DefaultArgon2Params.
 func HashPassword(password string) (string, error) {
-	hashed_password, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Println("Password hashing failed.")
+	return DefaultArgon2Params.Hash(password)
+}
+
+// Hash returns the Argon2id hash of password as a PHC string such as
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>.
+func (p Argon2Params) Hash(password string) (string, error) {
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	
-	return string(hashed_password), nil
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
+// NeedsRehash reports whether hash was made with anything other than
+// Argon2id at p, including every bcrypt hash.
+func (p Argon2Params) NeedsRehash(hash string) bool {
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != p.Memory || params.Iterations != p.Iterations ||
+		params.Parallelism != p.Parallelism ||
+		uint32(len(salt)) != p.SaltLength || uint32(len(key)) != p.KeyLength
+}
+
+// DummyCheck spends as long as checking a password against a hash made with
+// p and always fails. Logins for unknown emails call it so that response
+// times don't reveal which emails have accounts.
+func (p Argon2Params) DummyCheck(password string) {
+	argon2.IDKey([]byte(password), make([]byte, p.SaltLength), p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+}
+
+// CheckPasswordHash checks a password against an Argon2id PHC string or,
+// for accounts that haven't signed in since Argon2id was introduced, a bcrypt
+// hash.
 func CheckPasswordHash(hash, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
\ No newline at end of file
+	if strings.HasPrefix(hash, "$2") {
+		// bcrypt only looks at the first 72 bytes, so a longer password
+		// matches on its prefix until the hash is replaced.
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+	}
+
+	params, salt, key, err := parseArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+func parseArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return Argon2Params{}, nil, nil, errors.New("argon2id parameters must be positive")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errors.New("malformed argon2id hash")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters so the tests stay fast.
+var testArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2HashRoundTrip(t *testing.T) {
+	hash, err := testArgon2Params.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash returned error: %s", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("unexpected PHC string %q", hash)
+	}
+	if err := CheckPasswordHash(hash, "correct horse battery staple"); err != nil {
+		t.Errorf("expected password to match, got %s", err)
+	}
+	if err := CheckPasswordHash(hash, "correct horse battery stapler"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+}
+
+func TestArgon2HashesLongPasswordsInFull(t *testing.T) {
+	long := strings.Repeat("a", 80)
+	hash, err := testArgon2Params.Hash(long + "1")
+	if err != nil {
+		t.Fatalf("Hash returned error: %s", err)
+	}
+	if err := CheckPasswordHash(hash, long+"2"); err == nil {
+		t.Error("expected passwords differing after byte 72 not to match")
+	}
+}
+
+func TestCheckPasswordHashAcceptsBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("04234"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error generating bcrypt hash: %s", err)
+	}
+	if err := CheckPasswordHash(string(hash), "04234"); err != nil {
+		t.Errorf("expected bcrypt hash to match, got %s", err)
+	}
+	if err := CheckPasswordHash(string(hash), "04235"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("expected ErrPasswordMismatch, got %v", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("04234"), bcrypt.MinCost)
+	current, _ := testArgon2Params.Hash("04234")
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{"bcrypt", string(bcryptHash), true},
+		{"current parameters", current, false},
+		{"garbage", "not a hash", true},
+	}
+	for _, tt := range tests {
+		if got := testArgon2Params.NeedsRehash(tt.hash); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !stronger.NeedsRehash(current) {
+		t.Error("expected a hash with fewer iterations to need rehashing")
+	}
+}
+
+func TestCheckPasswordHashRejectsMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	} {
+		if err := CheckPasswordHash(hash, "password"); err == nil {
+			t.Errorf("expected %q to be rejected", hash)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens so they can be told
+// apart from JWTs in the same Authorization: Bearer header, and so secret
+// scanners can spot leaked ones.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + hex.EncodeToString(token), nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// EncryptSecret seals a value that must be stored recoverably (such as a TOTP
+// secret) with AES-256-GCM under a key derived from tokenSecret.
+func EncryptSecret(plaintext, tokenSecret string) (string, error) {
+	gcm, err := secretboxCipher(tokenSecret)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func DecryptSecret(ciphertext, tokenSecret string) (string, error) {
+	gcm, err := secretboxCipher(tokenSecret)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func secretboxCipher(tokenSecret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(purposeKey(tokenSecret, "chirpy-secretbox"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,14 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashToken returns the hex SHA-256 digest of an opaque token so that only
+// the digest needs to be stored. Tokens are high-entropy random values, so a
+// fast unsalted hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
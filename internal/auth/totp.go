@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults, which every authenticator app supports).
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new random 160-bit secret, base32 encoded.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI builds the otpauth:// URI that authenticator apps scan.
+func TOTPProvisioningURI(secret, accountName, issuer string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// TOTPCode computes the code for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, t.Unix()/totpPeriod), nil
+}
+
+// ValidateTOTP checks code against secret at time t, allowing one step of
+// clock skew either way. Codes from steps at or before lastUsedStep are
+// rejected so that a code can't be replayed. On success it returns the
+// matched step, which the caller should store as the new lastUsedStep.
+func ValidateTOTP(secret, code string, t time.Time, lastUsedStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp implements RFC 4226 with HMAC-SHA1 and dynamic truncation.
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// GenerateRecoveryCodes returns n single-use codes formatted like
+// "abcde-fghij". Store only their HashRecoveryCode digests.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		for j, c := range raw {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(alphabet[c&31])
+		}
+		codes = append(codes, b.String())
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode normalizes a user-typed recovery code and hashes it.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return HashToken(normalized)
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RFC 6238 appendix B vectors for the SHA-1 key "12345678901234567890",
+// truncated to 6 digits.
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		code, err := TOTPCode(secret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode returned error: %s", err)
+		}
+		if code != c.code {
+			t.Errorf("at %d: expected %s, got %s", c.unix, c.code, code)
+		}
+	}
+}
+
+func TestValidateTOTPRejectsReplay(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret returned error: %s", err)
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok {
+		t.Fatal("expected current code to validate")
+	}
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("expected replayed code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "000000x", now, 0); ok {
+		t.Error("expected malformed code to be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("JBSWY3DPEHPK3PXP", "user@example.com", "Chirpy")
+	if !strings.HasPrefix(uri, "otpauth://totp/Chirpy:user@example.com?") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("unexpected provisioning URI: %s", uri)
+	}
+}
+
+func TestRecoveryCodeHashIgnoresFormatting(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("GenerateRecoveryCodes returned %v, %s", codes, err)
+	}
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	if HashRecoveryCode(typed) != HashRecoveryCode(codes[0]) {
+		t.Error("expected recovery code hash to ignore case and dashes")
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	sealed, err := EncryptSecret("JBSWY3DPEHPK3PXP", "ThIsIsAsEcReTtOkEn9876")
+	if err != nil {
+		t.Fatalf("EncryptSecret returned error: %s", err)
+	}
+	plain, err := DecryptSecret(sealed, "ThIsIsAsEcReTtOkEn9876")
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("round trip failed: %q, %v", plain, err)
+	}
+	if _, err := DecryptSecret(sealed, "incorrectamundo"); err == nil {
+		t.Error("expected decryption with the wrong secret to fail")
+	}
+}
+
+func TestMFAChallengeTokenIsNotAnAccessToken(t *testing.T) {
+	secret := "ThIsIsAsEcReTtOkEn9876"
+	userID := uuid.New()
+
+	token, err := MakeMFAChallengeToken(userID, secret, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating challenge token: %s", err)
+	}
+	if got, err := ValidateMFAChallengeToken(token, secret); err != nil || got != userID {
+		t.Errorf("challenge token did not validate: %s", err)
+	}
+	if _, err := ValidateJWT(token, secret); err == nil {
+		t.Error("challenge token was accepted as an access token")
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const emailVerificationAudience = "chirpy-email-verification"
+
+type emailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// EmailVerification is the payload carried by a verification token.
+type EmailVerification struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+	Email  string
+}
+
+// purposeKey derives a signing key for a single token purpose so that, for
+// example, a verification token can never be accepted as an access token.
+func purposeKey(tokenSecret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(tokenSecret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func MakeEmailVerificationToken(id, userID uuid.UUID, email, tokenSecret string, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, emailVerificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			Issuer:    "chirpy",
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	})
+
+	return token.SignedString(purposeKey(tokenSecret, emailVerificationAudience))
+}
+
+func ValidateEmailVerificationToken(tokenString, tokenSecret string) (EmailVerification, error) {
+	claims := emailVerificationClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		return purposeKey(tokenSecret, emailVerificationAudience), nil
+	}
+
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(emailVerificationAudience),
+		jwt.WithIssuer("chirpy"),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return EmailVerification{}, err
+	}
+
+	id, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return EmailVerification{}, err
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return EmailVerification{}, err
+	}
+	if claims.Email == "" {
+		return EmailVerification{}, errors.New("verification token missing email")
+	}
+
+	return EmailVerification{ID: id, UserID: userID, Email: claims.Email}, nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEmailVerificationTokenRoundTrip(t *testing.T) {
+	id, userID := uuid.New(), uuid.New()
+	secret := "ThIsIsAsEcReTtOkEn9876"
+
+	token, err := MakeEmailVerificationToken(id, userID, "user@example.com", secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating verification token: %s", err)
+	}
+
+	v, err := ValidateEmailVerificationToken(token, secret)
+	if err != nil {
+		t.Fatalf("Error validating verification token: %s", err)
+	}
+	if v.ID != id || v.UserID != userID || v.Email != "user@example.com" {
+		t.Errorf("unexpected verification payload: %+v", v)
+	}
+}
+
+func TestEmailVerificationTokenIsNotAnAccessToken(t *testing.T) {
+	secret := "ThIsIsAsEcReTtOkEn9876"
+
+	token, err := MakeEmailVerificationToken(uuid.New(), uuid.New(), "user@example.com", secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating verification token: %s", err)
+	}
+	if _, err := ValidateJWT(token, secret); err == nil {
+		t.Error("verification token was accepted as an access token")
+	}
+
+	access, err := MakeJWT(uuid.New(), secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating jwt: %s", err)
+	}
+	if _, err := ValidateEmailVerificationToken(access, secret); err == nil {
+		t.Error("access token was accepted as a verification token")
+	}
+}
@@ -0,0 +1,636 @@
+// Package config loads Chirpy's settings.
+//
+// Each setting has a default and can be set, from lowest precedence to
+// highest, in a TOML file, by an environment variable and by a command-line
+// flag. The file is named by the -config flag or CHIRPY_CONFIG. Its keys,
+// which are also the flag names, are dotted paths such as "server.port",
+// written in the file as a port key in a [server] table. The environment
+// variables keep the names Chirpy has always read, such as PORT and DB_URL.
+// Membership tiers are only set in the file, as tables under [entitlements].
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/entitlements"
+)
+
+// Secret is a setting that must not be shown, such as a key or password.
+// It prints as "[redacted]"; Reveal returns the value.
+type Secret string
+
+const redacted = "[redacted]"
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Server struct {
+	Port     int
+	FileRoot string
+	// ShutdownTimeout is how long in-flight requests and running jobs get
+	// to finish on shutdown.
+	ShutdownTimeout time.Duration
+	// TrustedProxies lists, comma separated, the networks of load balancers
+	// in front of Chirpy, whose X-Forwarded-For is believed.
+	TrustedProxies string
+}
+
+type JWT struct {
+	Issuer   string
+	Audience string
+	// KeysDir holds the signing keys. Without it an ephemeral key is used.
+	KeysDir      string
+	SigningKeyID string
+}
+
+type Mail struct {
+	// Mailer is "file", which writes messages to Dir, or "smtp".
+	Mailer       string
+	From         string
+	Dir          string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword Secret
+}
+
+type Passwords struct {
+	MinLength int
+	// BreachedFile lists passwords to refuse, one per line, on top of the
+	// bundled common passwords.
+	BreachedFile      string
+	Argon2MemoryKiB   int
+	Argon2Iterations  int
+	Argon2Parallelism int
+}
+
+type Payments struct {
+	PolkaKey            Secret
+	StripeWebhookSecret Secret
+}
+
+// OIDCProvider is a sign-in provider. In the file each is a table under
+// [oidc]; in the environment, OIDC_PROVIDERS names them and OIDC_NAME_ISSUER
+// and so on configure provider NAME.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret Secret
+	RedirectURL  string
+	Scopes       []string
+}
+
+type Config struct {
+	// Platform is "dev" for local development, which allows things such as
+	// resetting the database.
+	Platform string
+	DBURL    Secret
+	// Secret signs and encrypts short-lived tokens and cookies.
+	Secret Secret
+
+	Server    Server
+	JWT       JWT
+	Mail      Mail
+	Passwords Passwords
+	Payments  Payments
+	OIDC      map[string]OIDCProvider
+	// Entitlements are what each membership tier may do. In the file each
+	// tier is a table under [entitlements], whose keys override the
+	// tier's defaults.
+	Entitlements map[string]entitlements.Entitlements
+
+	RequireEmailVerification bool
+	AccountDeletionGrace     time.Duration
+	RefreshTokenRetention    time.Duration
+	WebhookRetention         time.Duration
+	JobRetention             time.Duration
+	ExportDir                string
+}
+
+// Default returns the settings used when nothing else is given. It has no
+// database URL, platform or secret, so it does not validate.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:            8080,
+			FileRoot:        ".",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		JWT: JWT{
+			Issuer:   "chirpy",
+			Audience: "chirpy-api",
+		},
+		Mail: Mail{
+			Mailer:   "file",
+			From:     "Chirpy <noreply@chirpy.local>",
+			Dir:      "mail",
+			SMTPPort: 587,
+		},
+		Passwords: Passwords{
+			MinLength:         8,
+			Argon2MemoryKiB:   int(auth.DefaultArgon2Params.Memory),
+			Argon2Iterations:  int(auth.DefaultArgon2Params.Iterations),
+			Argon2Parallelism: int(auth.DefaultArgon2Params.Parallelism),
+		},
+		OIDC:                     map[string]OIDCProvider{},
+		Entitlements:             maps.Clone(entitlements.DefaultTiers),
+		RequireEmailVerification: true,
+		AccountDeletionGrace:     30 * 24 * time.Hour,
+		RefreshTokenRetention:    7 * 24 * time.Hour,
+		WebhookRetention:         30 * 24 * time.Hour,
+		JobRetention:             7 * 24 * time.Hour,
+		ExportDir:                "exports",
+	}
+}
+
+// setting is one configurable value. key names it in the file and as a
+// flag; env names its environment variable.
+type setting struct {
+	key   string
+	env   string
+	usage string
+	value any
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"platform", "PLATFORM", `"dev" for local development`, &c.Platform},
+		{"db_url", "DB_URL", "Postgres connection URL", &c.DBURL},
+		{"secret", "SECRET", "key for signing short-lived tokens", &c.Secret},
+		{"server.port", "PORT", "port to listen on", &c.Server.Port},
+		{"server.file_root", "FILEPATH_ROOT", "directory served under /app/", &c.Server.FileRoot},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "time allowed to finish requests and jobs on shutdown", &c.Server.ShutdownTimeout},
+		{"server.trusted_proxies", "TRUSTED_PROXIES", "comma-separated networks of load balancers in front of the server", &c.Server.TrustedProxies},
+		{"jwt.issuer", "JWT_ISSUER", "access token issuer", &c.JWT.Issuer},
+		{"jwt.audience", "JWT_AUDIENCE", "access token audience", &c.JWT.Audience},
+		{"jwt.keys_dir", "JWT_KEYS_DIR", "directory of access token signing keys", &c.JWT.KeysDir},
+		{"jwt.signing_key_id", "JWT_SIGNING_KEY_ID", "key in jwt.keys_dir to sign with", &c.JWT.SigningKeyID},
+		{"mail.mailer", "MAILER", `"file" or "smtp"`, &c.Mail.Mailer},
+		{"mail.from", "MAIL_FROM", "sender of outgoing mail", &c.Mail.From},
+		{"mail.dir", "MAIL_DIR", "directory the file mailer writes to", &c.Mail.Dir},
+		{"mail.smtp_host", "SMTP_HOST", "SMTP relay host", &c.Mail.SMTPHost},
+		{"mail.smtp_port", "SMTP_PORT", "SMTP relay port", &c.Mail.SMTPPort},
+		{"mail.smtp_username", "SMTP_USERNAME", "SMTP username", &c.Mail.SMTPUsername},
+		{"mail.smtp_password", "SMTP_PASSWORD", "SMTP password", &c.Mail.SMTPPassword},
+		{"passwords.min_length", "PASSWORD_MIN_LENGTH", "shortest password allowed", &c.Passwords.MinLength},
+		{"passwords.breached_file", "BREACHED_PASSWORDS_FILE", "file of passwords to refuse", &c.Passwords.BreachedFile},
+		{"passwords.argon2_memory_kib", "ARGON2_MEMORY_KIB", "Argon2id memory cost", &c.Passwords.Argon2MemoryKiB},
+		{"passwords.argon2_iterations", "ARGON2_ITERATIONS", "Argon2id iterations", &c.Passwords.Argon2Iterations},
+		{"passwords.argon2_parallelism", "ARGON2_PARALLELISM", "Argon2id parallelism", &c.Passwords.Argon2Parallelism},
+		{"payments.polka_key", "POLKA_KEY", "Polka webhook key", &c.Payments.PolkaKey},
+		{"payments.stripe_webhook_secret", "STRIPE_WEBHOOK_SECRET", "Stripe webhook signing secret", &c.Payments.StripeWebhookSecret},
+		{"require_email_verification", "REQUIRE_EMAIL_VERIFICATION", "require a verified email for posting", &c.RequireEmailVerification},
+		{"account_deletion_grace", "ACCOUNT_DELETION_GRACE_PERIOD", "time before a deleted account is purged", &c.AccountDeletionGrace},
+		{"refresh_token_retention", "REFRESH_TOKEN_RETENTION", "time expired and revoked refresh tokens are kept", &c.RefreshTokenRetention},
+		{"webhook_retention", "WEBHOOK_RETENTION", "time inbound webhook deliveries are kept", &c.WebhookRetention},
+		{"job_retention", "JOB_RETENTION", "time finished background jobs are kept", &c.JobRetention},
+		{"export_dir", "EXPORT_DIR", "directory for data export archives", &c.ExportDir},
+	}
+}
+
+// set parses v into the setting.
+func (s setting) set(v string) error {
+	switch dst := s.value.(type) {
+	case *string:
+		*dst = v
+	case *Secret:
+		*dst = Secret(v)
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", s.key, v)
+		}
+		*dst = n
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a boolean, got %q", s.key, v)
+		}
+		*dst = b
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 90s or 720h, got %q", s.key, v)
+		}
+		*dst = d
+	case *entitlements.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 15m, got %q", s.key, v)
+		}
+		*dst = entitlements.Duration(d)
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", dst))
+	}
+	return nil
+}
+
+// setFromFile stores a value decoded from TOML, which may already be typed.
+func (s setting) setFromFile(v any) error {
+	switch v := v.(type) {
+	case string:
+		if _, ok := s.value.(*int); ok {
+			return fmt.Errorf("%s must be an integer", s.key)
+		}
+		if _, ok := s.value.(*bool); ok {
+			return fmt.Errorf("%s must be a boolean", s.key)
+		}
+		return s.set(v)
+	case int64:
+		if dst, ok := s.value.(*int); ok {
+			*dst = int(v)
+			return nil
+		}
+	case bool:
+		if dst, ok := s.value.(*bool); ok {
+			*dst = v
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has the wrong type", s.key)
+}
+
+func (s setting) String() string {
+	switch v := s.value.(type) {
+	case *string:
+		return strconv.Quote(*v)
+	case *Secret:
+		return strconv.Quote(v.String())
+	case *time.Duration:
+		return strconv.Quote(v.String())
+	case *entitlements.Duration:
+		return strconv.Quote(time.Duration(*v).String())
+	case *int:
+		return strconv.Itoa(*v)
+	case *bool:
+		return strconv.FormatBool(*v)
+	}
+	return fmt.Sprint(s.value)
+}
+
+// Load reads the configuration from the file, environment and flags in
+// args, and validates it. It returns the arguments left after the flags.
+// getenv is usually os.Getenv.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	c := Default()
+	settings := c.settings()
+
+	// Flags win over everything, but name the file, so they are parsed
+	// first and applied last.
+	fs := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", getenv("CHIRPY_CONFIG"), "TOML configuration file")
+	var flagged []func() error
+	for _, s := range settings {
+		record := func(v string) error {
+			flagged = append(flagged, func() error { return s.set(v) })
+			return nil
+		}
+		if _, ok := s.value.(*bool); ok {
+			fs.BoolFunc(s.key, s.usage, record)
+		} else {
+			fs.Func(s.key, s.usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, fmt.Errorf("%w\n%s", err, Usage())
+		}
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if err := c.loadFile(*path, settings); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	if err := c.loadOIDCEnv(getenv); err != nil {
+		return nil, nil, err
+	}
+
+	for _, apply := range flagged {
+		if err := apply(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, fs.Args(), nil
+}
+
+// Usage describes the flags Load accepts.
+func Usage() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "  -config FILE\n\tTOML configuration file (env CHIRPY_CONFIG)\n")
+	for _, s := range Default().settings() {
+		fmt.Fprintf(&b, "  -%s\n\t%s (env %s)\n", s.key, s.usage, s.env)
+	}
+	return b.String()
+}
+
+func (c *Config) loadFile(path string, settings []setting) error {
+	var raw map[string]any
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if providers, ok := raw["oidc"]; ok {
+		delete(raw, "oidc")
+		if err := c.loadOIDCFile(providers); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	if tiers, ok := raw["entitlements"]; ok {
+		delete(raw, "entitlements")
+		if err := c.loadEntitlementsFile(tiers); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	values := map[string]any{}
+	flatten("", raw, values)
+	byKey := map[string]setting{}
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for key, v := range values {
+		s, ok := byKey[key]
+		if !ok {
+			return fmt.Errorf("reading %s: unknown setting %s", path, key)
+		}
+		if err := s.setFromFile(v); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// flatten turns nested tables into dotted keys.
+func flatten(prefix string, table map[string]any, into map[string]any) {
+	for k, v := range table {
+		if nested, ok := v.(map[string]any); ok {
+			flatten(prefix+k+".", nested, into)
+			continue
+		}
+		into[prefix+k] = v
+	}
+}
+
+func (c *Config) loadOIDCFile(v any) error {
+	tables, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("oidc must be a table of providers")
+	}
+	for name, t := range tables {
+		fields, ok := t.(map[string]any)
+		if !ok {
+			return fmt.Errorf("oidc.%s must be a table", name)
+		}
+		var p OIDCProvider
+		for k, v := range fields {
+			var dst *string
+			switch k {
+			case "issuer":
+				dst = &p.Issuer
+			case "client_id":
+				dst = &p.ClientID
+			case "client_secret":
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("oidc.%s.%s must be a string", name, k)
+				}
+				p.ClientSecret = Secret(s)
+				continue
+			case "redirect_url":
+				dst = &p.RedirectURL
+			case "scopes":
+				scopes, ok := v.([]any)
+				if !ok {
+					return fmt.Errorf("oidc.%s.scopes must be a list of strings", name)
+				}
+				for _, scope := range scopes {
+					s, ok := scope.(string)
+					if !ok {
+						return fmt.Errorf("oidc.%s.scopes must be a list of strings", name)
+					}
+					p.Scopes = append(p.Scopes, s)
+				}
+				continue
+			default:
+				return fmt.Errorf("unknown setting oidc.%s.%s", name, k)
+			}
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("oidc.%s.%s must be a string", name, k)
+			}
+			*dst = s
+		}
+		c.OIDC[strings.ToLower(name)] = p
+	}
+	return nil
+}
+
+// tierSettings are the settings of membership tier, which write to e.
+func tierSettings(tier string, e *entitlements.Entitlements) []setting {
+	prefix := "entitlements." + tier + "."
+	return []setting{
+		{key: prefix + "max_chirp_length", value: &e.MaxChirpLength},
+		{key: prefix + "edit_window", value: &e.EditWindow},
+		{key: prefix + "chirps_per_hour", value: &e.ChirpsPerHour},
+	}
+}
+
+func (c *Config) loadEntitlementsFile(v any) error {
+	tables, ok := v.(map[string]any)
+	if !ok {
+		return errors.New("entitlements must be a table of membership tiers")
+	}
+	for tier, t := range tables {
+		fields, ok := t.(map[string]any)
+		if !ok {
+			return fmt.Errorf("entitlements.%s must be a table", tier)
+		}
+		e, ok := c.Entitlements[tier]
+		if !ok {
+			return fmt.Errorf("unknown membership tier entitlements.%s", tier)
+		}
+		byKey := map[string]setting{}
+		for _, s := range tierSettings(tier, &e) {
+			byKey[s.key] = s
+		}
+		for k, v := range fields {
+			s, ok := byKey["entitlements."+tier+"."+k]
+			if !ok {
+				return fmt.Errorf("unknown setting entitlements.%s.%s", tier, k)
+			}
+			if err := s.setFromFile(v); err != nil {
+				return err
+			}
+		}
+		c.Entitlements[tier] = e
+	}
+	return nil
+}
+
+// loadOIDCEnv reads the providers named in OIDC_PROVIDERS (comma
+// separated). Each provider NAME is configured by OIDC_NAME_ISSUER,
+// OIDC_NAME_CLIENT_ID, OIDC_NAME_CLIENT_SECRET, OIDC_NAME_REDIRECT_URL and
+// OIDC_NAME_SCOPES (space separated), which override the file's settings.
+func (c *Config) loadOIDCEnv(getenv func(string) string) error {
+	for _, name := range strings.Split(getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		p := c.OIDC[name]
+		for suffix, dst := range map[string]*string{
+			"ISSUER":       &p.Issuer,
+			"CLIENT_ID":    &p.ClientID,
+			"REDIRECT_URL": &p.RedirectURL,
+		} {
+			if v := getenv(prefix + suffix); v != "" {
+				*dst = v
+			}
+		}
+		if v := getenv(prefix + "CLIENT_SECRET"); v != "" {
+			p.ClientSecret = Secret(v)
+		}
+		if v := getenv(prefix + "SCOPES"); v != "" {
+			p.Scopes = strings.Fields(v)
+		}
+		c.OIDC[name] = p
+	}
+	return nil
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Platform != "", "platform (PLATFORM) must be set")
+	check(c.DBURL != "", "db_url (DB_URL) must be set")
+	check(strings.TrimSpace(c.Secret.Reveal()) != "", "secret (SECRET) must be set")
+	check(c.Server.Port > 0 && c.Server.Port < 1<<16, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+
+	switch c.Mail.Mailer {
+	case "file":
+		check(c.Mail.Dir != "", "mail.dir must be set when mail.mailer is file")
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "mail.smtp_host (SMTP_HOST) must be set when mail.mailer is smtp")
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort < 1<<16, "mail.smtp_port must be between 1 and 65535, got %d", c.Mail.SMTPPort)
+	default:
+		errs = append(errs, fmt.Errorf("mail.mailer must be file or smtp, got %q", c.Mail.Mailer))
+	}
+
+	check(c.Passwords.MinLength >= 1, "passwords.min_length must be a positive integer, got %d", c.Passwords.MinLength)
+	check(c.Passwords.Argon2MemoryKiB >= 1 && c.Passwords.Argon2MemoryKiB <= 1<<32-1,
+		"passwords.argon2_memory_kib must be a positive 32-bit integer, got %d", c.Passwords.Argon2MemoryKiB)
+	check(c.Passwords.Argon2Iterations >= 1 && c.Passwords.Argon2Iterations <= 1<<32-1,
+		"passwords.argon2_iterations must be a positive 32-bit integer, got %d", c.Passwords.Argon2Iterations)
+	check(c.Passwords.Argon2Parallelism >= 1 && c.Passwords.Argon2Parallelism <= 255,
+		"passwords.argon2_parallelism must be between 1 and 255, got %d", c.Passwords.Argon2Parallelism)
+
+	check(c.AccountDeletionGrace >= 0, "account_deletion_grace must not be negative")
+	check(c.RefreshTokenRetention >= 0, "refresh_token_retention must not be negative")
+	check(c.WebhookRetention >= 0, "webhook_retention must not be negative")
+	check(c.JobRetention >= 0, "job_retention must not be negative")
+
+	for _, name := range c.oidcNames() {
+		p := c.OIDC[name]
+		check(p.Issuer != "" && p.ClientID != "" && p.RedirectURL != "",
+			"OIDC provider %s needs an issuer, client_id and redirect_url", name)
+		if p.RedirectURL != "" {
+			_, err := url.ParseRequestURI(p.RedirectURL)
+			check(err == nil, "OIDC provider %s has an invalid redirect_url", name)
+		}
+	}
+
+	if _, err := entitlements.New(c.Entitlements); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) oidcNames() []string {
+	names := make([]string, 0, len(c.OIDC))
+	for name := range c.OIDC {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String writes the configuration as TOML-style "key = value" lines, with
+// secrets redacted.
+func (c *Config) String() string {
+	var b strings.Builder
+	for _, s := range c.settings() {
+		fmt.Fprintf(&b, "%s = %s\n", s.key, s)
+	}
+	for _, name := range c.oidcNames() {
+		p := c.OIDC[name]
+		prefix := "oidc." + name + "."
+		fmt.Fprintf(&b, "%sissuer = %q\n", prefix, p.Issuer)
+		fmt.Fprintf(&b, "%sclient_id = %q\n", prefix, p.ClientID)
+		fmt.Fprintf(&b, "%sclient_secret = %q\n", prefix, p.ClientSecret.String())
+		fmt.Fprintf(&b, "%sredirect_url = %q\n", prefix, p.RedirectURL)
+		scopes := make([]string, 0, len(p.Scopes))
+		for _, scope := range p.Scopes {
+			scopes = append(scopes, strconv.Quote(scope))
+		}
+		fmt.Fprintf(&b, "%sscopes = [%s]\n", prefix, strings.Join(scopes, ", "))
+	}
+	for _, tier := range slices.Sorted(maps.Keys(c.Entitlements)) {
+		e := c.Entitlements[tier]
+		for _, s := range tierSettings(tier, &e) {
+			fmt.Fprintf(&b, "%s = %s\n", s.key, s)
+		}
+	}
+	return b.String()
+}
@@ -12,33 +12,51 @@ import (
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, user_agent, ip_address, last_used_at)
 VALUES (
     $1,
     NOW(),
     NOW(),
     NOW() + INTERVAL '60 days',
     NULL,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
 )
-RETURNING token, created_at, updated_at, expires_at, revoked_at, user_id
+RETURNING token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, rotated_at, user_agent, ip_address, last_used_at
 `
 
 type CreateRefreshTokenParams struct {
-	Token  string    `json:"token"`
-	UserID uuid.UUID `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	UserID    uuid.UUID `json:"user_id"`
+	FamilyID  uuid.UUID `json:"family_id"`
+	UserAgent string    `json:"user_agent"`
+	IpAddress string    `json:"ip_address"`
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
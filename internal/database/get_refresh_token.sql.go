@@ -10,20 +10,25 @@ import (
 )
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, expires_at, revoked_at, user_id FROM refresh_tokens
-WHERE token = $1
+SELECT token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, rotated_at, user_agent, ip_address, last_used_at FROM refresh_tokens
+WHERE token_hash = $1
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
 	)
 	return i, err
 }
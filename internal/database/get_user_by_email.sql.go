@@ -10,7 +10,7 @@ import (
 )
 
 const userLogin = `-- name: UserLogin :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, deletion_scheduled_at, role FROM users
 WHERE email = $1
 `
 
@@ -24,6 +24,9 @@ func (q *Queries) UserLogin(ctx context.Context, email string) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeletionScheduledAt,
+		&i.Role,
 	)
 	return i, err
 }
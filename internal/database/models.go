@@ -11,6 +11,19 @@ import (
 	"github.com/google/uuid"
 )
 
+type AuditEvent struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	Action    string        `json:"action"`
+	Outcome   string        `json:"outcome"`
+	ActorID   uuid.NullUUID `json:"actor_id"`
+	UserID    uuid.NullUUID `json:"user_id"`
+	Detail    string        `json:"detail"`
+	IpAddress string        `json:"ip_address"`
+	UserAgent string        `json:"user_agent"`
+	RequestID string        `json:"request_id"`
+}
+
 type Chirp struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -19,20 +32,253 @@ type Chirp struct {
 	UserID    uuid.UUID `json:"user_id"`
 }
 
-type RefreshToken struct {
-	Token     string       `json:"token"`
+type DataExport struct {
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Status    string         `json:"status"`
+	FilePath  sql.NullString `json:"file_path"`
+	Error     sql.NullString `json:"error"`
+	ExpiresAt sql.NullTime   `json:"expires_at"`
+	UserID    uuid.UUID      `json:"user_id"`
+}
+
+type EmailVerification struct {
+	ID        uuid.UUID    `json:"id"`
 	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
 	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	Email     string       `json:"email"`
+	UserID    uuid.UUID    `json:"user_id"`
+}
+
+type Job struct {
+	ID          uuid.UUID      `json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Kind        string         `json:"kind"`
+	Payload     string         `json:"payload"`
+	Status      string         `json:"status"`
+	Attempts    int32          `json:"attempts"`
+	RunAt       time.Time      `json:"run_at"`
+	LockedUntil sql.NullTime   `json:"locked_until"`
+	LastError   string         `json:"last_error"`
+	FinishedAt  sql.NullTime   `json:"finished_at"`
+	UniqueKey   sql.NullString `json:"unique_key"`
+}
+
+type LegacyAccount struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type LoginThrottle struct {
+	Key          string       `json:"key"`
+	Failures     int32        `json:"failures"`
+	LastFailedAt time.Time    `json:"last_failed_at"`
+	LockedUntil  sql.NullTime `json:"locked_until"`
+}
+
+type Membership struct {
+	UserID             uuid.UUID    `json:"user_id"`
+	CreatedAt          time.Time    `json:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at"`
+	Status             string       `json:"status"`
+	CurrentPeriodStart time.Time    `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time    `json:"current_period_end"`
+	GraceUntil         sql.NullTime `json:"grace_until"`
+	CanceledAt         sql.NullTime `json:"canceled_at"`
+}
+
+type MembershipEvent struct {
+	ID              uuid.UUID      `json:"id"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UserID          uuid.UUID      `json:"user_id"`
+	Event           string         `json:"event"`
+	Source          string         `json:"source"`
+	ProviderEventID sql.NullString `json:"provider_event_id"`
+	Status          string         `json:"status"`
+	PeriodEnd       sql.NullTime   `json:"period_end"`
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CodeHash  string       `json:"code_hash"`
+	UserID    uuid.UUID    `json:"user_id"`
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string       `json:"code_hash"`
+	CreatedAt     time.Time    `json:"created_at"`
+	ExpiresAt     time.Time    `json:"expires_at"`
+	UsedAt        sql.NullTime `json:"used_at"`
+	RedirectUri   string       `json:"redirect_uri"`
+	CodeChallenge string       `json:"code_challenge"`
+	GrantID       uuid.UUID    `json:"grant_id"`
+}
+
+type OauthClient struct {
+	ID           uuid.UUID      `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	Name         string         `json:"name"`
+	SecretHash   sql.NullString `json:"secret_hash"`
+	RedirectUris []string       `json:"redirect_uris"`
+	OwnerID      uuid.UUID      `json:"owner_id"`
+}
+
+type OauthGrant struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Scopes    []string     `json:"scopes"`
 	RevokedAt sql.NullTime `json:"revoked_at"`
+	ClientID  uuid.UUID    `json:"client_id"`
 	UserID    uuid.UUID    `json:"user_id"`
 }
 
+type OauthRefreshToken struct {
+	TokenHash string       `json:"token_hash"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	RotatedAt sql.NullTime `json:"rotated_at"`
+	GrantID   uuid.UUID    `json:"grant_id"`
+}
+
+type Outbox struct {
+	ID            int64        `json:"id"`
+	CreatedAt     time.Time    `json:"created_at"`
+	AggregateType string       `json:"aggregate_type"`
+	AggregateID   string       `json:"aggregate_id"`
+	EventType     string       `json:"event_type"`
+	Payload       string       `json:"payload"`
+	Attempts      int32        `json:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	LastError     string       `json:"last_error"`
+	PublishedAt   sql.NullTime `json:"published_at"`
+	FailedAt      sql.NullTime `json:"failed_at"`
+}
+
+type OutboundWebhookDelivery struct {
+	ID             uuid.UUID     `json:"id"`
+	CreatedAt      time.Time     `json:"created_at"`
+	EndpointID     uuid.UUID     `json:"endpoint_id"`
+	EventID        uuid.UUID     `json:"event_id"`
+	EventType      string        `json:"event_type"`
+	Payload        string        `json:"payload"`
+	Status         string        `json:"status"`
+	Attempts       int32         `json:"attempts"`
+	NextAttemptAt  time.Time     `json:"next_attempt_at"`
+	LastAttemptAt  sql.NullTime  `json:"last_attempt_at"`
+	ResponseStatus sql.NullInt32 `json:"response_status"`
+	Error          string        `json:"error"`
+}
+
+type PasswordReset struct {
+	TokenHash string       `json:"token_hash"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+}
+
+type PaymentCustomer struct {
+	Provider   string    `json:"provider"`
+	CustomerID string    `json:"customer_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID    `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+	Name       string       `json:"name"`
+	TokenHash  string       `json:"token_hash"`
+	Scopes     []string     `json:"scopes"`
+	ExpiresAt  time.Time    `json:"expires_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+	UserID     uuid.UUID    `json:"user_id"`
+}
+
+type RefreshToken struct {
+	TokenHash  string       `json:"token_hash"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+	ExpiresAt  time.Time    `json:"expires_at"`
+	RevokedAt  sql.NullTime `json:"revoked_at"`
+	UserID     uuid.UUID    `json:"user_id"`
+	FamilyID   uuid.UUID    `json:"family_id"`
+	RotatedAt  sql.NullTime `json:"rotated_at"`
+	UserAgent  string       `json:"user_agent"`
+	IpAddress  string       `json:"ip_address"`
+	LastUsedAt time.Time    `json:"last_used_at"`
+}
+
 type User struct {
-	ID             uuid.UUID `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"hashed_password"`
-	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ID                  uuid.UUID    `json:"id"`
+	CreatedAt           time.Time    `json:"created_at"`
+	UpdatedAt           time.Time    `json:"updated_at"`
+	Email               string       `json:"email"`
+	HashedPassword      string       `json:"hashed_password"`
+	IsChirpyRed         bool         `json:"is_chirpy_red"`
+	EmailVerifiedAt     sql.NullTime `json:"email_verified_at"`
+	DeletionScheduledAt sql.NullTime `json:"deletion_scheduled_at"`
+	Role                string       `json:"role"`
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+type UserTotp struct {
+	UserID          uuid.UUID    `json:"user_id"`
+	CreatedAt       time.Time    `json:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	EncryptedSecret string       `json:"encrypted_secret"`
+	ConfirmedAt     sql.NullTime `json:"confirmed_at"`
+	LastUsedStep    int64        `json:"last_used_step"`
+}
+
+type WebhookDelivery struct {
+	ID            uuid.UUID    `json:"id"`
+	Provider      string       `json:"provider"`
+	ReceivedAt    time.Time    `json:"received_at"`
+	Headers       string       `json:"headers"`
+	Body          string       `json:"body"`
+	EventID       string       `json:"event_id"`
+	EventType     string       `json:"event_type"`
+	Status        string       `json:"status"`
+	Error         string       `json:"error"`
+	Attempts      int32        `json:"attempts"`
+	LastAttemptAt sql.NullTime `json:"last_attempt_at"`
+}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID    `json:"id"`
+	CreatedAt           time.Time    `json:"created_at"`
+	UpdatedAt           time.Time    `json:"updated_at"`
+	UserID              uuid.UUID    `json:"user_id"`
+	Url                 string       `json:"url"`
+	EncryptedSecret     string       `json:"encrypted_secret"`
+	Events              []string     `json:"events"`
+	ConsecutiveFailures int32        `json:"consecutive_failures"`
+	DisabledAt          sql.NullTime `json:"disabled_at"`
+	DisabledReason      string       `json:"disabled_reason"`
+}
+
+type WebhookEvent struct {
+	Provider   string    `json:"provider"`
+	EventID    string    `json:"event_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	EventType  string    `json:"event_type"`
+	Payload    string    `json:"payload"`
 }
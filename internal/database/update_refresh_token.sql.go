@@ -9,21 +9,48 @@ import (
 	"context"
 	"database/sql"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL
+`
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateRefreshToken, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateRefreshToken = `-- name: UpdateRefreshToken :exec
 UPDATE refresh_tokens
 SET updated_at = $2, revoked_at = $3
-WHERE token = $1
+WHERE token_hash = $1
 `
 
 type UpdateRefreshTokenParams struct {
-	Token     string       `json:"token"`
+	TokenHash string       `json:"token_hash"`
 	UpdatedAt time.Time    `json:"updated_at"`
 	RevokedAt sql.NullTime `json:"revoked_at"`
 }
 
 func (q *Queries) UpdateRefreshToken(ctx context.Context, arg UpdateRefreshTokenParams) error {
-	_, err := q.db.ExecContext(ctx, updateRefreshToken, arg.Token, arg.UpdatedAt, arg.RevokedAt)
+	_, err := q.db.ExecContext(ctx, updateRefreshToken, arg.TokenHash, arg.UpdatedAt, arg.RevokedAt)
 	return err
 }
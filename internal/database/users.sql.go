@@ -7,10 +7,22 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const cancelUserDeletion = `-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = NULL, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, cancelUserDeletion, id)
+	return err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
@@ -20,7 +32,7 @@ VALUES (
     $2,
     $1
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, deletion_scheduled_at, role
 `
 
 type CreateUserParams struct {
@@ -38,15 +50,158 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeletionScheduledAt,
+		&i.Role,
 	)
 	return i, err
 }
 
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, deletion_scheduled_at, role FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeletionScheduledAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :exec
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+}
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) error {
+	_, err := q.db.ExecContext(ctx, markEmailVerified, arg.ID, arg.Email)
+	return err
+}
+
+const purgeScheduledUserDeletions = `-- name: PurgeScheduledUserDeletions :many
+DELETE FROM users
+WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1
+RETURNING id
+`
+
+func (q *Queries) PurgeScheduledUserDeletions(ctx context.Context, deletionScheduledAt sql.NullTime) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, purgeScheduledUserDeletions, deletionScheduledAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type ScheduleUserDeletionParams struct {
+	ID                  uuid.UUID    `json:"id"`
+	DeletionScheduledAt sql.NullTime `json:"deletion_scheduled_at"`
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleUserDeletion, arg.ID, arg.DeletionScheduledAt)
+	return err
+}
+
+const setUserChirpyRed = `-- name: SetUserChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserChirpyRedParams struct {
+	ID          uuid.UUID `json:"id"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+}
+
+func (q *Queries) SetUserChirpyRed(ctx context.Context, arg SetUserChirpyRedParams) error {
+	_, err := q.db.ExecContext(ctx, setUserChirpyRed, arg.ID, arg.IsChirpyRed)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID `json:"id"`
+	Role string    `json:"role"`
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRole, arg.ID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserRoleByEmail = `-- name: SetUserRoleByEmail :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE email = $1
+`
+
+type SetUserRoleByEmailParams struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (q *Queries) SetUserRoleByEmail(ctx context.Context, arg SetUserRoleByEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserRoleByEmail, arg.Email, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = $2,
+    hashed_password = $3,
+    updated_at = NOW(),
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, deletion_scheduled_at, role
 `
 
 type UpdateUserParams struct {
@@ -65,6 +220,25 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.DeletionScheduledAt,
+		&i.Role,
 	)
 	return i, err
 }
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID `json:"id"`
+	HashedPassword string    `json:"hashed_password"`
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
@@ -0,0 +1,106 @@
+// Package entitlements decides what each membership tier may do. Handlers
+// ask it rather than checking for Chirpy Red themselves, so perks can be
+// changed in configuration.
+package entitlements
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Tiers a user can be on.
+const (
+	TierFree      = "free"
+	TierChirpyRed = "chirpy_red"
+)
+
+// Entitlements are the capabilities of one tier.
+type Entitlements struct {
+	// MaxChirpLength is the longest chirp body allowed, in bytes.
+	MaxChirpLength int `json:"max_chirp_length"`
+	// EditWindow is how long after posting a chirp may be edited; zero
+	// means chirps can't be edited.
+	EditWindow Duration `json:"edit_window"`
+	// ChirpsPerHour caps how many chirps may be posted in any hour; zero
+	// means no cap.
+	ChirpsPerHour int `json:"chirps_per_hour"`
+}
+
+// Duration is a time.Duration written in JSON as a string such as "15m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Service maps tiers to their entitlements.
+type Service struct {
+	tiers map[string]Entitlements
+}
+
+// DefaultTiers are used when no configuration is given.
+var DefaultTiers = map[string]Entitlements{
+	TierFree: {
+		MaxChirpLength: 140,
+		ChirpsPerHour:  30,
+	},
+	TierChirpyRed: {
+		MaxChirpLength: 560,
+		EditWindow:     Duration(15 * time.Minute),
+		ChirpsPerHour:  300,
+	},
+}
+
+// New returns a Service for tiers, which must define every tier.
+func New(tiers map[string]Entitlements) (*Service, error) {
+	for _, tier := range []string{TierFree, TierChirpyRed} {
+		e, ok := tiers[tier]
+		if !ok {
+			return nil, fmt.Errorf("entitlements: tier %q is not defined", tier)
+		}
+		if e.MaxChirpLength < 1 {
+			return nil, fmt.Errorf("entitlements: tier %q needs a positive max_chirp_length", tier)
+		}
+		if e.EditWindow < 0 || e.ChirpsPerHour < 0 {
+			return nil, fmt.Errorf("entitlements: tier %q has a negative limit", tier)
+		}
+	}
+	return &Service{tiers: tiers}, nil
+}
+
+// TierFor returns the tier of a user by membership.
+func TierFor(isChirpyRed bool) string {
+	if isChirpyRed {
+		return TierChirpyRed
+	}
+	return TierFree
+}
+
+// For returns the entitlements of tier, or of the free tier if tier is
+// unknown.
+func (s *Service) For(tier string) Entitlements {
+	if e, ok := s.tiers[tier]; ok {
+		return e
+	}
+	return s.tiers[TierFree]
+}
+
+// CanEdit reports whether a chirp posted at createdAt may still be edited at
+// now.
+func (e Entitlements) CanEdit(createdAt, now time.Time) bool {
+	return e.EditWindow > 0 && now.Sub(createdAt) <= time.Duration(e.EditWindow)
+}
@@ -0,0 +1,60 @@
+package entitlements
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFor(t *testing.T) {
+	s, err := New(map[string]Entitlements{
+		TierFree:      {MaxChirpLength: 100},
+		TierChirpyRed: {MaxChirpLength: 1000, EditWindow: Duration(5 * time.Minute), ChirpsPerHour: 50},
+	})
+	if err != nil {
+		t.Fatalf("New returned error: %s", err)
+	}
+
+	red := s.For(TierFor(true))
+	if red.MaxChirpLength != 1000 || time.Duration(red.EditWindow) != 5*time.Minute || red.ChirpsPerHour != 50 {
+		t.Errorf("unexpected chirpy_red entitlements %+v", red)
+	}
+	if free := s.For(TierFor(false)); free.MaxChirpLength != 100 || free.EditWindow != 0 {
+		t.Errorf("unexpected free entitlements %+v", free)
+	}
+	if unknown := s.For("platinum"); unknown.MaxChirpLength != 100 {
+		t.Errorf("expected an unknown tier to get the free tier, got %+v", unknown)
+	}
+}
+
+func TestNewRejectsBadTiers(t *testing.T) {
+	for name, tiers := range map[string]map[string]Entitlements{
+		"missing tier":   {TierFree: {MaxChirpLength: 140}},
+		"zero length":    {TierFree: {MaxChirpLength: 0}, TierChirpyRed: {MaxChirpLength: 1}},
+		"negative limit": {TierFree: {MaxChirpLength: 1, ChirpsPerHour: -1}, TierChirpyRed: {MaxChirpLength: 1}},
+		"negative edit":  {TierFree: {MaxChirpLength: 1}, TierChirpyRed: {MaxChirpLength: 1, EditWindow: Duration(-time.Minute)}},
+	} {
+		if _, err := New(tiers); err == nil {
+			t.Errorf("%s: expected New to fail", name)
+		}
+	}
+}
+
+func TestCanEdit(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	e := Entitlements{EditWindow: Duration(15 * time.Minute)}
+	if !e.CanEdit(created, created.Add(15*time.Minute)) {
+		t.Error("expected an edit at the end of the window to be allowed")
+	}
+	if e.CanEdit(created, created.Add(16*time.Minute)) {
+		t.Error("expected an edit after the window to be refused")
+	}
+	if (Entitlements{}).CanEdit(created, created) {
+		t.Error("expected no editing without an edit window")
+	}
+}
+
+func TestDefaultTiersAreValid(t *testing.T) {
+	if _, err := New(DefaultTiers); err != nil {
+		t.Fatalf("DefaultTiers are invalid: %s", err)
+	}
+}
@@ -0,0 +1,432 @@
+// Package jobs runs background work from a durable queue.
+//
+// Jobs are stored, so they survive restarts, and claimed under a lease, so
+// a job whose worker died is picked up again once the lease runs out. That
+// makes execution at least once: handlers must tolerate running a job
+// twice. Failed jobs are retried with backoff until their kind's attempt
+// limit, then left failed for inspection.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	firstRetryDelay = 10 * time.Second
+	maxRetryDelay   = time.Hour
+
+	defaultConcurrency  = 4
+	defaultPollInterval = time.Second
+	defaultLease        = 10 * time.Minute
+	defaultMaxAttempts  = 5
+	defaultTimeout      = 5 * time.Minute
+
+	maxErrorLength = 500
+)
+
+// ErrDuplicate is returned by Enqueue when a job with the same unique key
+// already exists.
+var ErrDuplicate = errors.New("jobs: duplicate job")
+
+// Job is a job claimed from the queue.
+type Job struct {
+	ID      uuid.UUID
+	Kind    string
+	Payload []byte
+	// Attempts counts this one.
+	Attempts int
+}
+
+// NewJob describes a job to enqueue.
+type NewJob struct {
+	Kind    string
+	Payload []byte
+	RunAt   time.Time
+	// UniqueKey, if set, makes enqueueing a second job with the same key
+	// fail with ErrDuplicate.
+	UniqueKey string
+}
+
+// Enqueuer adds jobs to the queue. Enqueueing with a transaction makes the
+// job run if and only if the transaction commits.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, job NewJob) (uuid.UUID, error)
+}
+
+// Store holds the queue.
+type Store interface {
+	Enqueuer
+	// Claim leases up to limit runnable jobs of the given kinds until
+	// leaseUntil, counting an attempt for each. Jobs that are due and jobs
+	// whose lease has run out are runnable.
+	Claim(ctx context.Context, kinds []string, limit int, leaseUntil time.Time) ([]Job, error)
+	Complete(ctx context.Context, id uuid.UUID) error
+	Retry(ctx context.Context, id uuid.UUID, runAt time.Time, lastError string) error
+	Fail(ctx context.Context, id uuid.UUID, lastError string) error
+}
+
+// Kind is a kind of job with payloads of type T, which are stored as JSON.
+type Kind[T any] struct {
+	name string
+}
+
+func NewKind[T any](name string) Kind[T] {
+	return Kind[T]{name: name}
+}
+
+func (k Kind[T]) Name() string {
+	return k.name
+}
+
+// EnqueueOption adjusts a job being enqueued.
+type EnqueueOption func(*NewJob)
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) EnqueueOption {
+	return func(job *NewJob) { job.RunAt = t }
+}
+
+// UniqueKey makes the job the only one with key; see NewJob.
+func UniqueKey(key string) EnqueueOption {
+	return func(job *NewJob) { job.UniqueKey = key }
+}
+
+// Enqueue adds a job of this kind to run as soon as possible.
+func (k Kind[T]) Enqueue(ctx context.Context, e Enqueuer, payload T, opts ...EnqueueOption) (uuid.UUID, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("jobs: encoding %s payload: %w", k.name, err)
+	}
+	job := NewJob{Kind: k.name, Payload: data, RunAt: time.Now().UTC()}
+	for _, opt := range opts {
+		opt(&job)
+	}
+	return e.Enqueue(ctx, job)
+}
+
+// HandlerOptions limit how a kind of job runs. Zero values take defaults.
+type HandlerOptions struct {
+	// MaxAttempts is how many times a job is tried before it fails for
+	// good. The default is 5.
+	MaxAttempts int
+	// Timeout bounds each attempt. The default is 5 minutes.
+	Timeout time.Duration
+}
+
+type handler struct {
+	run         func(ctx context.Context, payload []byte) error
+	maxAttempts int
+	timeout     time.Duration
+}
+
+// permanentError is a failure retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Handle has fn run jobs of kind k.
+func Handle[T any](q *Queue, k Kind[T], fn func(ctx context.Context, payload T) error, opts HandlerOptions) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[k.name] = handler{
+		run: func(ctx context.Context, data []byte) error {
+			var payload T
+			if err := json.Unmarshal(data, &payload); err != nil {
+				return permanentError{fmt.Errorf("decoding payload: %w", err)}
+			}
+			return fn(ctx, payload)
+		},
+		maxAttempts: opts.MaxAttempts,
+		timeout:     opts.Timeout,
+	}
+}
+
+type recurring struct {
+	kind     string
+	payload  []byte
+	schedule Schedule
+	next     time.Time
+}
+
+// ScheduleRecurring enqueues a job of kind k with payload every time the
+// cron schedule spec fires (see ParseSchedule). Each firing is enqueued
+// once however many queues share the store.
+func ScheduleRecurring[T any](q *Queue, k Kind[T], spec string, payload T) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("jobs: encoding %s payload: %w", k.name, err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.recurring = append(q.recurring, &recurring{
+		kind:     k.name,
+		payload:  data,
+		schedule: schedule,
+		next:     schedule.Next(time.Now()),
+	})
+	return nil
+}
+
+// Options configure a Queue. Zero values take defaults.
+type Options struct {
+	// Concurrency is how many jobs run at once. The default is 4.
+	Concurrency int
+	// PollInterval is how often the store is checked for jobs. The
+	// default is a second.
+	PollInterval time.Duration
+	// Lease is how long a claimed job is kept from other workers. It
+	// should outlast every handler's timeout. The default is 10 minutes.
+	Lease time.Duration
+}
+
+// Queue runs jobs from a store with the handlers registered on it.
+type Queue struct {
+	store Store
+	opts  Options
+
+	mu        sync.Mutex
+	handlers  map[string]handler
+	recurring []*recurring
+
+	slots      chan struct{}
+	running    sync.WaitGroup
+	jobCtx     context.Context
+	cancelJobs context.CancelFunc
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func New(store Store, opts Options) *Queue {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = defaultLease
+	}
+
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	return &Queue{
+		store:      store,
+		opts:       opts,
+		handlers:   map[string]handler{},
+		slots:      make(chan struct{}, opts.Concurrency),
+		jobCtx:     jobCtx,
+		cancelJobs: cancelJobs,
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// Start begins running jobs in the background.
+func (q *Queue) Start() {
+	q.startOnce.Do(func() { go q.run() })
+}
+
+// Shutdown stops claiming jobs and waits for running ones to finish. If ctx
+// ends first, running jobs are canceled, and retried later, and Shutdown
+// returns once they have returned.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	q.startOnce.Do(func() { close(q.stopped) })
+	<-q.stopped
+
+	drained := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		q.cancelJobs()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.enqueueRecurring(time.Now())
+		q.poll()
+
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueueRecurring enqueues every recurring job that has come due, keyed
+// by the time it was due so other queues enqueueing it too are ignored.
+func (q *Queue) enqueueRecurring(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, r := range q.recurring {
+		if r.next.IsZero() || now.Before(r.next) {
+			continue
+		}
+		_, err := q.store.Enqueue(context.Background(), NewJob{
+			Kind:      r.kind,
+			Payload:   r.payload,
+			RunAt:     r.next,
+			UniqueKey: fmt.Sprintf("recurring:%s:%d", r.kind, r.next.Unix()),
+		})
+		if err != nil && !errors.Is(err, ErrDuplicate) {
+			log.Printf("Error enqueueing recurring %s job: %s", r.kind, err)
+			continue
+		}
+		r.next = r.schedule.Next(now)
+	}
+}
+
+// poll claims as many jobs as there are free slots and starts them.
+func (q *Queue) poll() {
+	free := cap(q.slots) - len(q.slots)
+	if free == 0 {
+		return
+	}
+
+	q.mu.Lock()
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+	q.mu.Unlock()
+	if len(kinds) == 0 {
+		return
+	}
+
+	claimed, err := q.store.Claim(context.Background(), kinds, free, time.Now().UTC().Add(q.opts.Lease))
+	if err != nil {
+		log.Printf("Error claiming jobs: %s", err)
+		return
+	}
+	for _, job := range claimed {
+		q.slots <- struct{}{}
+		q.running.Add(1)
+		go func() {
+			defer func() {
+				<-q.slots
+				q.running.Done()
+			}()
+			q.execute(job)
+		}()
+	}
+}
+
+func (q *Queue) execute(job Job) {
+	q.mu.Lock()
+	h, ok := q.handlers[job.Kind]
+	q.mu.Unlock()
+	if !ok {
+		q.fail(job, fmt.Errorf("no handler for %s jobs", job.Kind))
+		return
+	}
+
+	// A worker that died mid-job has already used this attempt.
+	if job.Attempts > h.maxAttempts {
+		q.fail(job, fmt.Errorf("gave up after %d attempts", h.maxAttempts))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(q.jobCtx, h.timeout)
+	err := runHandler(ctx, h, job.Payload)
+	cancel()
+
+	var permanent permanentError
+	switch {
+	case err == nil:
+		if err := q.store.Complete(context.Background(), job.ID); err != nil {
+			log.Printf("Error completing %s job %s: %s", job.Kind, job.ID, err)
+		}
+	case q.jobCtx.Err() != nil:
+		// Interrupted by shutdown: run it again as soon as possible.
+		q.retry(job, time.Now().UTC(), err)
+	case errors.As(err, &permanent) || job.Attempts >= h.maxAttempts:
+		q.fail(job, err)
+	default:
+		q.retry(job, time.Now().UTC().Add(RetryDelay(job.Attempts)), err)
+	}
+}
+
+// runHandler runs a job, turning a panic into an error.
+func runHandler(ctx context.Context, h handler, payload []byte) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Panic running job: %v\n%s", p, debug.Stack())
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return h.run(ctx, payload)
+}
+
+func (q *Queue) retry(job Job, runAt time.Time, jobErr error) {
+	log.Printf("%s job %s failed on attempt %d, retrying: %s", job.Kind, job.ID, job.Attempts, jobErr)
+	if err := q.store.Retry(context.Background(), job.ID, runAt, truncateError(jobErr)); err != nil {
+		log.Printf("Error rescheduling %s job %s: %s", job.Kind, job.ID, err)
+	}
+}
+
+func (q *Queue) fail(job Job, jobErr error) {
+	log.Printf("%s job %s failed: %s", job.Kind, job.ID, jobErr)
+	if err := q.store.Fail(context.Background(), job.ID, truncateError(jobErr)); err != nil {
+		log.Printf("Error failing %s job %s: %s", job.Kind, job.ID, err)
+	}
+}
+
+func truncateError(err error) string {
+	msg := err.Error()
+	if len(msg) > maxErrorLength {
+		msg = msg[:maxErrorLength]
+	}
+	return msg
+}
+
+// RetryDelay is how long to wait before retrying a job that has failed
+// attempts times.
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
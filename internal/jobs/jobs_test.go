@@ -0,0 +1,353 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryJob struct {
+	NewJob
+	id          uuid.UUID
+	status      string
+	attempts    int
+	lockedUntil time.Time
+	lastError   string
+}
+
+// memoryStore is a Store kept in memory.
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs []*memoryJob
+}
+
+func (s *memoryStore) Enqueue(ctx context.Context, job NewJob) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job.UniqueKey != "" {
+		for _, j := range s.jobs {
+			if j.UniqueKey == job.UniqueKey {
+				return uuid.Nil, ErrDuplicate
+			}
+		}
+	}
+	j := &memoryJob{NewJob: job, id: uuid.New(), status: "queued"}
+	s.jobs = append(s.jobs, j)
+	return j.id, nil
+}
+
+func (s *memoryStore) Claim(ctx context.Context, kinds []string, limit int, leaseUntil time.Time) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var claimed []Job
+	for _, j := range s.jobs {
+		if len(claimed) == limit {
+			break
+		}
+		due := j.status == "queued" && !j.RunAt.After(now)
+		expired := j.status == "running" && j.lockedUntil.Before(now)
+		if !slices.Contains(kinds, j.Kind) || !(due || expired) {
+			continue
+		}
+		j.status = "running"
+		j.attempts++
+		j.lockedUntil = leaseUntil
+		claimed = append(claimed, Job{ID: j.id, Kind: j.Kind, Payload: j.Payload, Attempts: j.attempts})
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) update(id uuid.UUID, fn func(j *memoryJob)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.id == id {
+			fn(j)
+			return nil
+		}
+	}
+	return errors.New("no such job")
+}
+
+func (s *memoryStore) Complete(ctx context.Context, id uuid.UUID) error {
+	return s.update(id, func(j *memoryJob) { j.status = "succeeded" })
+}
+
+func (s *memoryStore) Retry(ctx context.Context, id uuid.UUID, runAt time.Time, lastError string) error {
+	return s.update(id, func(j *memoryJob) {
+		j.status = "queued"
+		j.RunAt = runAt
+		j.lastError = lastError
+	})
+}
+
+func (s *memoryStore) Fail(ctx context.Context, id uuid.UUID, lastError string) error {
+	return s.update(id, func(j *memoryJob) {
+		j.status = "failed"
+		j.lastError = lastError
+	})
+}
+
+func (s *memoryStore) get(id uuid.UUID) memoryJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.id == id {
+			return *j
+		}
+	}
+	return memoryJob{}
+}
+
+// waitFor polls cond until it holds or a few seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type greeting struct {
+	Name string `json:"name"`
+}
+
+var greet = NewKind[greeting]("greet")
+
+func TestQueueRunsTypedJobs(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+
+	got := make(chan string, 1)
+	Handle(q, greet, func(ctx context.Context, g greeting) error {
+		got <- g.Name
+		return nil
+	}, HandlerOptions{})
+
+	id, err := greet.Enqueue(context.Background(), store, greeting{Name: "Gopher"})
+	if err != nil {
+		t.Fatalf("Enqueue returned error: %s", err)
+	}
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	select {
+	case name := <-got:
+		if name != "Gopher" {
+			t.Errorf("handler got %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job never ran")
+	}
+	waitFor(t, "completion", func() bool { return store.get(id).status == "succeeded" })
+}
+
+func TestQueueRetriesThenFails(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+
+	var calls atomic.Int32
+	Handle(q, greet, func(ctx context.Context, g greeting) error {
+		calls.Add(1)
+		return errors.New("smtp unavailable")
+	}, HandlerOptions{MaxAttempts: 2})
+
+	id, _ := greet.Enqueue(context.Background(), store, greeting{})
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	waitFor(t, "retry", func() bool { return store.get(id).status == "queued" && calls.Load() == 1 })
+	job := store.get(id)
+	if job.lastError != "smtp unavailable" {
+		t.Errorf("unexpected last error %q", job.lastError)
+	}
+	if delay := time.Until(job.RunAt); delay < 5*time.Second {
+		t.Errorf("expected the retry to back off, got %s", delay)
+	}
+
+	// Bring the retry forward rather than waiting out the backoff.
+	store.update(id, func(j *memoryJob) { j.RunAt = time.Now() })
+	waitFor(t, "failure", func() bool { return store.get(id).status == "failed" })
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls.Load())
+	}
+}
+
+func TestQueueFailsUndecodablePayloadsAtOnce(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+	Handle(q, greet, func(ctx context.Context, g greeting) error { return nil }, HandlerOptions{})
+
+	id, _ := store.Enqueue(context.Background(), NewJob{Kind: "greet", Payload: []byte("not json"), RunAt: time.Now()})
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	waitFor(t, "failure", func() bool { return store.get(id).status == "failed" })
+}
+
+func TestQueueRecoversPanics(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+	Handle(q, greet, func(ctx context.Context, g greeting) error { panic("boom") }, HandlerOptions{MaxAttempts: 1})
+
+	id, _ := greet.Enqueue(context.Background(), store, greeting{})
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	waitFor(t, "failure", func() bool { return store.get(id).status == "failed" })
+	if got := store.get(id).lastError; got != "panic: boom" {
+		t.Errorf("unexpected last error %q", got)
+	}
+}
+
+func TestQueueLimitsConcurrency(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{Concurrency: 2, PollInterval: 5 * time.Millisecond})
+
+	var running, peak, done atomic.Int32
+	Handle(q, greet, func(ctx context.Context, g greeting) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+		running.Add(-1)
+		done.Add(1)
+		return nil
+	}, HandlerOptions{})
+
+	for range 6 {
+		greet.Enqueue(context.Background(), store, greeting{})
+	}
+	q.Start()
+	defer q.Shutdown(context.Background())
+
+	waitFor(t, "all jobs", func() bool { return done.Load() == 6 })
+	if p := peak.Load(); p != 2 {
+		t.Errorf("expected at most 2 jobs at once, saw %d", p)
+	}
+}
+
+func TestShutdownDrainsRunningJobs(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+
+	started := make(chan struct{})
+	Handle(q, greet, func(ctx context.Context, g greeting) error {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}, HandlerOptions{})
+
+	id, _ := greet.Enqueue(context.Background(), store, greeting{})
+	q.Start()
+	<-started
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %s", err)
+	}
+	if status := store.get(id).status; status != "succeeded" {
+		t.Errorf("expected the running job to finish before Shutdown returned, got %s", status)
+	}
+}
+
+func TestShutdownCancelsJobsAfterDeadline(t *testing.T) {
+	store := &memoryStore{}
+	q := New(store, Options{PollInterval: 10 * time.Millisecond})
+
+	started := make(chan struct{})
+	Handle(q, greet, func(ctx context.Context, g greeting) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, HandlerOptions{MaxAttempts: 1})
+
+	id, _ := greet.Enqueue(context.Background(), store, greeting{})
+	q.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline error, got %v", err)
+	}
+	// Interrupted jobs are put back rather than failed.
+	if status := store.get(id).status; status != "queued" {
+		t.Errorf("expected the interrupted job to be queued again, got %s", status)
+	}
+}
+
+func TestRecurringJobsAreEnqueuedOncePerFiring(t *testing.T) {
+	store := &memoryStore{}
+	first := New(store, Options{})
+	second := New(store, Options{})
+	for _, q := range []*Queue{first, second} {
+		if err := ScheduleRecurring(q, greet, "*/5 * * * *", greeting{Name: "cron"}); err != nil {
+			t.Fatalf("ScheduleRecurring returned error: %s", err)
+		}
+	}
+
+	due := first.recurring[0].next
+	for _, q := range []*Queue{first, second} {
+		q.enqueueRecurring(due.Add(-time.Second))
+	}
+	if len(store.jobs) != 0 {
+		t.Fatalf("expected nothing enqueued before the schedule fires, got %d", len(store.jobs))
+	}
+
+	for _, q := range []*Queue{first, second} {
+		q.enqueueRecurring(due)
+	}
+	if len(store.jobs) != 1 {
+		t.Fatalf("expected one job per firing, got %d", len(store.jobs))
+	}
+	if job := store.jobs[0]; !job.RunAt.Equal(due) || string(job.Payload) != `{"name":"cron"}` {
+		t.Errorf("unexpected job %+v", job.NewJob)
+	}
+	if next := first.recurring[0].next; !next.Equal(due.Add(5 * time.Minute)) {
+		t.Errorf("expected the next firing 5 minutes later, got %s", next)
+	}
+}
+
+func TestScheduleRecurringRejectsBadSpec(t *testing.T) {
+	if err := ScheduleRecurring(New(&memoryStore{}, Options{}), greet, "every hour", greeting{}); err == nil {
+		t.Error("expected an invalid schedule to be rejected")
+	}
+}
+
+func TestEnqueueReportsDuplicates(t *testing.T) {
+	store := &memoryStore{}
+	if _, err := greet.Enqueue(context.Background(), store, greeting{}, UniqueKey("k")); err != nil {
+		t.Fatalf("Enqueue returned error: %s", err)
+	}
+	if _, err := greet.Enqueue(context.Background(), store, greeting{}, UniqueKey("k")); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		9:  2560 * time.Second,
+		10: time.Hour,
+		40: time.Hour,
+	} {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
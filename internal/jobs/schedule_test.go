@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Monday.
+	from := time.Date(2026, 10, 19, 10, 7, 30, 0, time.UTC)
+
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 10, 19, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 19, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 10, 20, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Day of month or day of week when both are given.
+		{"0 0 1 * 3", time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10 * * *", time.Date(2026, 10, 19, 10, 10, 0, 0, time.UTC)},
+	} {
+		s, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) returned error: %s", tc.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tc.want) {
+			t.Errorf("%q: Next = %s, want %s", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleNeverFires(t *testing.T) {
+	s, err := ParseSchedule("0 0 31 2 *")
+	if err != nil {
+		t.Fatalf("ParseSchedule returned error: %s", err)
+	}
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("expected February 31st never to come, got %s", next)
+	}
+}
+
+func TestParseScheduleRejectsBadSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("expected ParseSchedule(%q) to fail", spec)
+		}
+	}
+}
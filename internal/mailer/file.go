@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer drops each message into Dir as an .eml file instead of sending
+// it. Intended for local development.
+type FileMailer struct {
+	Dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{Dir: dir}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), msg.Bytes(), 0o644)
+}
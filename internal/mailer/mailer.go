@@ -0,0 +1,54 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Mailer delivers a single email message.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+func (m Message) validate() error {
+	if m.From == "" {
+		return errors.New("message missing From address")
+	}
+	if len(m.To) == 0 {
+		return errors.New("message has no recipients")
+	}
+	for _, addr := range append([]string{m.From}, m.To...) {
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	if strings.ContainsAny(m.Subject, "\r\n") {
+		return errors.New("subject must not contain line breaks")
+	}
+	return nil
+}
+
+// Bytes renders the message in RFC 5322 format with CRLF line endings.
+func (m Message) Bytes() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes()
+}
@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMemoryMailerRecordsMessages(t *testing.T) {
+	m := NewMemoryMailer()
+	msg := Message{From: "noreply@chirpy.test", To: []string{"user@example.com"}, Subject: "Hello", Body: "hi"}
+
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send returned error: %s", err)
+	}
+
+	last, ok := m.Last()
+	if !ok {
+		t.Fatal("expected a recorded message")
+	}
+	if last.Subject != "Hello" || len(m.Messages()) != 1 {
+		t.Errorf("unexpected messages: %+v", m.Messages())
+	}
+}
+
+func TestFileMailerWritesEml(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatalf("NewFileMailer returned error: %s", err)
+	}
+
+	msg := Message{From: "noreply@chirpy.test", To: []string{"user@example.com"}, Subject: "Verify", Body: "line one\nline two"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send returned error: %s", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, found %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "Subject: Verify\r\n") || !strings.Contains(string(data), "line one\r\nline two") {
+		t.Errorf("unexpected file contents:\n%s", data)
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	m := NewMemoryMailer()
+	msg := Message{From: "noreply@chirpy.test", To: []string{"user@example.com\r\nBcc: evil@example.com"}, Subject: "x"}
+
+	if err := m.Send(context.Background(), msg); err == nil {
+		t.Error("expected header injection to be rejected")
+	}
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer keeps sent messages in memory so tests can inspect them.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far.
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Message, len(m.messages))
+	copy(out, m.messages)
+	return out
+}
+
+// Last returns the most recently sent message.
+func (m *MemoryMailer) Last() (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		return Message{}, false
+	}
+	return m.messages[len(m.messages)-1], true
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/smtp"
+)
+
+// SMTPMailer sends messages through an SMTP relay using PLAIN auth when a
+// username is configured.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+func NewSMTPMailer(host, port, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// The envelope needs bare addresses; the headers keep any display names.
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return err
+	}
+	to := make([]string, 0, len(msg.To))
+	for _, addr := range msg.To {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return err
+		}
+		to = append(to, parsed.Address)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, from.Address, to, msg.Bytes())
+}
@@ -0,0 +1,280 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+// authorizeRequest is a validated authorization request. The consent form
+// carries its parameters through in hidden fields, and the POST validates
+// them again from scratch.
+type authorizeRequest struct {
+	Client        Client
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// authorizeError is a problem with an authorization request. With redirect
+// set it is reported to the client at its redirect URI; otherwise the client
+// or redirect URI can't be trusted and the user gets an error page instead.
+type authorizeError struct {
+	code        string
+	description string
+	redirect    bool
+}
+
+func (s *Server) parseAuthorizeRequest(ctx context.Context, form url.Values) (authorizeRequest, *authorizeError) {
+	clientID, err := uuid.Parse(form.Get("client_id"))
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{"invalid_request", "Missing or malformed client_id.", false}
+	}
+	client, err := s.Store.GetClient(ctx, clientID)
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{"invalid_client", "Unknown client.", false}
+	}
+	req := authorizeRequest{
+		Client:      client,
+		RedirectURI: form.Get("redirect_uri"),
+		State:       form.Get("state"),
+	}
+	// Redirect URIs must match a registered one exactly, or the code could
+	// be sent anywhere.
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return req, &authorizeError{"invalid_request", "redirect_uri is not registered for this client.", false}
+	}
+
+	if form.Get("response_type") != "code" {
+		return req, &authorizeError{"unsupported_response_type", "Only response_type=code is supported.", true}
+	}
+	req.CodeChallenge = form.Get("code_challenge")
+	if form.Get("code_challenge_method") != "S256" || !codeChallengePattern.MatchString(req.CodeChallenge) {
+		return req, &authorizeError{"invalid_request", "PKCE with code_challenge_method=S256 is required.", true}
+	}
+	req.Scopes = strings.Fields(form.Get("scope"))
+	if len(req.Scopes) == 0 {
+		return req, &authorizeError{"invalid_scope", "At least one scope is required.", true}
+	}
+	for _, scope := range req.Scopes {
+		if _, ok := s.Scopes[scope]; !ok {
+			return req, &authorizeError{"invalid_scope", "Unknown scope " + scope + ".", true}
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	return req, nil
+}
+
+// HandleAuthorize shows the consent screen for GET /oauth/authorize.
+func (s *Server) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, authErr := s.parseAuthorizeRequest(r.Context(), r.URL.Query())
+	if authErr != nil {
+		s.respondWithAuthorizeError(w, r, req, authErr)
+		return
+	}
+	s.renderConsent(w, http.StatusOK, req, consentForm{})
+}
+
+// HandleAuthorizeSubmit handles the consent form. The user signs in and
+// approves in the same step, so there is no session cookie to forge a
+// request against.
+func (s *Server) HandleAuthorizeSubmit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Couldn't parse form.", http.StatusBadRequest)
+		return
+	}
+	req, authErr := s.parseAuthorizeRequest(r.Context(), r.PostForm)
+	if authErr != nil {
+		s.respondWithAuthorizeError(w, r, req, authErr)
+		return
+	}
+
+	if r.PostForm.Get("action") != "approve" {
+		s.redirectWithParams(w, r, req.RedirectURI, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"The user denied the request."},
+		}, req.State)
+		return
+	}
+
+	form := consentForm{Email: r.PostForm.Get("email"), NeedOTP: r.PostForm.Get("otp") != ""}
+	userID, err := s.Users.Authenticate(r, form.Email, r.PostForm.Get("password"), r.PostForm.Get("otp"))
+	if errors.Is(err, ErrSecondFactorRequired) {
+		form.NeedOTP = true
+		if r.PostForm.Get("otp") != "" {
+			form.Error = "Incorrect two-factor code."
+		} else {
+			form.Error = "Enter the code from your authenticator app or a recovery code."
+		}
+		s.renderConsent(w, http.StatusUnauthorized, req, form)
+		return
+	}
+	if errors.Is(err, ErrTooManyAttempts) {
+		form.Error = "Too many failed sign-in attempts. Try again later."
+		s.renderConsent(w, http.StatusTooManyRequests, req, form)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Error signing in for OAuth consent: %s", err)
+		}
+		form.Error = "Incorrect email or password."
+		s.renderConsent(w, http.StatusUnauthorized, req, form)
+		return
+	}
+
+	grant := Grant{ID: uuid.New(), ClientID: req.Client.ID, UserID: userID, Scopes: req.Scopes}
+	if err := s.Store.CreateGrant(r.Context(), grant); err != nil {
+		log.Printf("Error creating OAuth grant: %s", err)
+		s.redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"server_error"}}, req.State)
+		return
+	}
+	code, err := NewSecret()
+	if err == nil {
+		err = s.Store.CreateAuthorizationCode(r.Context(), AuthorizationCode{
+			CodeHash:      auth.HashToken(code),
+			GrantID:       grant.ID,
+			RedirectURI:   req.RedirectURI,
+			CodeChallenge: req.CodeChallenge,
+			ExpiresAt:     time.Now().UTC().Add(s.CodeTTL),
+		})
+	}
+	if err != nil {
+		log.Printf("Error creating OAuth authorization code: %s", err)
+		s.redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"server_error"}}, req.State)
+		return
+	}
+
+	s.redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}}, req.State)
+}
+
+func (s *Server) respondWithAuthorizeError(w http.ResponseWriter, r *http.Request, req authorizeRequest, authErr *authorizeError) {
+	if authErr.redirect {
+		s.redirectWithParams(w, r, req.RedirectURI, url.Values{
+			"error":             {authErr.code},
+			"error_description": {authErr.description},
+		}, req.State)
+		return
+	}
+	setPageHeaders(w)
+	w.WriteHeader(http.StatusBadRequest)
+	if err := errorPage.Execute(w, authErr.description); err != nil {
+		log.Printf("Error rendering OAuth error page: %s", err)
+	}
+}
+
+// redirectWithParams sends the user back to the client, keeping any query
+// the registered redirect URI already has.
+func (s *Server) redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values, state string) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri.", http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+type consentForm struct {
+	Email   string
+	NeedOTP bool
+	Error   string
+}
+
+type consentScope struct {
+	Name        string
+	Description string
+}
+
+func (s *Server) renderConsent(w http.ResponseWriter, status int, req authorizeRequest, form consentForm) {
+	scopes := make([]consentScope, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		scopes = append(scopes, consentScope{Name: scope, Description: s.Scopes[scope]})
+	}
+	data := struct {
+		Request authorizeRequest
+		Scope   string
+		Scopes  []consentScope
+		Form    consentForm
+	}{req, strings.Join(req.Scopes, " "), scopes, form}
+
+	setPageHeaders(w)
+	w.WriteHeader(status)
+	if err := consentPage.Execute(w, data); err != nil {
+		log.Printf("Error rendering OAuth consent page: %s", err)
+	}
+}
+
+// setPageHeaders keeps the consent screen out of frames, so it can't be
+// clickjacked, and out of caches.
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.Request.Client.Name}} - Chirpy</title>
+</head>
+<body>
+<h1>Authorize {{.Request.Client.Name}}</h1>
+<p><strong>{{.Request.Client.Name}}</strong> wants to access your Chirpy account. It will be able to:</p>
+<ul>
+{{range .Scopes}}<li>{{.Description}} (<code>{{.Name}}</code>)</li>
+{{end}}</ul>
+{{if .Form.Error}}<p role="alert">{{.Form.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.Request.Client.ID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+<p><label>Email <input type="email" name="email" value="{{.Form.Email}}" autocomplete="username" required></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+{{if .Form.NeedOTP}}<p><label>Two-factor code <input type="text" name="otp" autocomplete="one-time-code" required></label></p>
+{{end}}<p>
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</p>
+</form>
+<p>You'll be sent back to {{.Request.RedirectURI}}.</p>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorization error - Chirpy</title>
+</head>
+<body>
+<h1>This app's request can't be completed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
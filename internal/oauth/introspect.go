@@ -0,0 +1,134 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+)
+
+// Introspection is an RFC 7662 introspection response. Inactive tokens
+// report nothing but active: false.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+}
+
+// lookupToken resolves an access or refresh token issued by this server to
+// its grant. ok is false for anything unknown, expired, revoked or not an
+// OAuth token at all.
+func (s *Server) lookupToken(ctx context.Context, token string) (grant Grant, info Introspection, ok bool, err error) {
+	if token == "" {
+		return Grant{}, Introspection{}, false, nil
+	}
+
+	rt, err := s.Store.GetRefreshToken(ctx, auth.HashToken(token))
+	if err == nil {
+		grant, err = s.Store.GetGrant(ctx, rt.GrantID)
+		if err != nil {
+			return Grant{}, Introspection{}, false, err
+		}
+		if rt.Rotated || time.Now().UTC().After(rt.ExpiresAt) {
+			return grant, Introspection{}, false, nil
+		}
+		info = Introspection{TokenType: "refresh_token", ExpiresAt: rt.ExpiresAt.Unix()}
+	} else if errors.Is(err, ErrNotFound) {
+		claims, err := s.Tokens.ValidateAccessToken(token)
+		if err != nil || claims.ClientID == "" {
+			return Grant{}, Introspection{}, false, nil
+		}
+		grant, err = s.Store.GetGrant(ctx, claims.SessionID)
+		if errors.Is(err, ErrNotFound) {
+			return Grant{}, Introspection{}, false, nil
+		}
+		if err != nil {
+			return Grant{}, Introspection{}, false, err
+		}
+		info = Introspection{
+			TokenType: "access_token",
+			Scope:     strings.Join(claims.Scopes, " "),
+			ExpiresAt: claims.ExpiresAt.Unix(),
+			IssuedAt:  claims.IssuedAt.Unix(),
+		}
+	} else {
+		return Grant{}, Introspection{}, false, err
+	}
+
+	if grant.Revoked {
+		return grant, Introspection{}, false, nil
+	}
+	info.Active = true
+	if info.Scope == "" {
+		info.Scope = strings.Join(grant.Scopes, " ")
+	}
+	info.ClientID = grant.ClientID.String()
+	info.Subject = grant.UserID.String()
+	info.Issuer = s.Tokens.Issuer()
+	info.Audience = s.Tokens.Audience()
+	return grant, info, true, nil
+}
+
+// HandleIntrospect is POST /oauth/introspect (RFC 7662). A client may only
+// introspect tokens issued to itself; anyone else's read as inactive.
+func (s *Server) HandleIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, r, &tokenError{http.StatusBadRequest, "invalid_request", "Couldn't parse form."})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+
+	grant, info, ok, err := s.lookupToken(r.Context(), r.PostForm.Get("token"))
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	if !ok || grant.ClientID != client.ID {
+		writeJSON(w, http.StatusOK, Introspection{Active: false})
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// HandleRevoke is POST /oauth/revoke (RFC 7009). Access tokens are
+// stateless JWTs, so revoking either kind of token revokes the grant behind
+// it, and with it every token the client holds for that user. Unknown
+// tokens still get a 200, as the RFC requires.
+func (s *Server) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, r, &tokenError{http.StatusBadRequest, "invalid_request", "Couldn't parse form."})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+
+	grant, _, ok, err := s.lookupToken(r.Context(), r.PostForm.Get("token"))
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	if ok && grant.ClientID == client.ID {
+		if err := s.Store.RevokeGrant(r.Context(), grant.ID); err != nil {
+			writeTokenError(w, r, err)
+			return
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
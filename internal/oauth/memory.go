@@ -0,0 +1,132 @@
+package oauth
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore keeps everything in maps. It is meant for tests.
+type MemoryStore struct {
+	mu            sync.Mutex
+	clients       map[uuid.UUID]Client
+	grants        map[uuid.UUID]Grant
+	codes         map[string]AuthorizationCode
+	refreshTokens map[string]RefreshToken
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		clients:       map[uuid.UUID]Client{},
+		grants:        map[uuid.UUID]Grant{},
+		codes:         map[string]AuthorizationCode{},
+		refreshTokens: map[string]RefreshToken{},
+	}
+}
+
+// AddClient registers a client. Real registration happens outside the
+// authorization server, so Store has no equivalent.
+func (m *MemoryStore) AddClient(client Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client.RedirectURIs = slices.Clone(client.RedirectURIs)
+	m.clients[client.ID] = client
+}
+
+func (m *MemoryStore) GetClient(ctx context.Context, id uuid.UUID) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok {
+		return Client{}, ErrNotFound
+	}
+	return client, nil
+}
+
+func (m *MemoryStore) CreateGrant(ctx context.Context, grant Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grant.Scopes = slices.Clone(grant.Scopes)
+	m.grants[grant.ID] = grant
+	return nil
+}
+
+func (m *MemoryStore) GetGrant(ctx context.Context, id uuid.UUID) (Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grant, ok := m.grants[id]
+	if !ok {
+		return Grant{}, ErrNotFound
+	}
+	return grant, nil
+}
+
+func (m *MemoryStore) RevokeGrant(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if grant, ok := m.grants[id]; ok {
+		grant.Revoked = true
+		m.grants[id] = grant
+	}
+	return nil
+}
+
+func (m *MemoryStore) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code.CodeHash] = code
+	return nil
+}
+
+func (m *MemoryStore) GetAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[codeHash]
+	if !ok {
+		return AuthorizationCode{}, ErrNotFound
+	}
+	return code, nil
+}
+
+func (m *MemoryStore) UseAuthorizationCode(ctx context.Context, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[codeHash]
+	if !ok || code.Used {
+		return false, nil
+	}
+	code.Used = true
+	m.codes[codeHash] = code
+	return true, nil
+}
+
+func (m *MemoryStore) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *MemoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	return token, nil
+}
+
+func (m *MemoryStore) RotateRefreshToken(ctx context.Context, tokenHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok || token.Rotated {
+		return false, nil
+	}
+	token.Rotated = true
+	m.refreshTokens[tokenHash] = token
+	return true, nil
+}
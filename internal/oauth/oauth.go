@@ -0,0 +1,144 @@
+// Package oauth is Chirpy's OAuth 2.0 authorization server: the
+// authorization code flow with PKCE (RFC 6749, RFC 7636), token
+// introspection (RFC 7662) and token revocation (RFC 7009).
+//
+// Storage and user sign-in are supplied by the caller, so the whole flow can
+// be exercised with httptest and a MemoryStore.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound = errors.New("oauth: not found")
+
+	// ErrInvalidCredentials, ErrSecondFactorRequired and ErrTooManyAttempts
+	// are returned by Users.Authenticate.
+	ErrInvalidCredentials   = errors.New("oauth: invalid credentials")
+	ErrSecondFactorRequired = errors.New("oauth: second factor required")
+	ErrTooManyAttempts      = errors.New("oauth: too many failed sign-in attempts")
+)
+
+// Client is a registered third-party application. Public clients (native and
+// browser apps that can't keep a secret) have no SecretHash and rely on PKCE
+// alone.
+type Client struct {
+	ID           uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+}
+
+func (c Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// Grant is a user's consent for a client to act with some scopes. Every
+// token issued to the client hangs off a grant, so revoking the grant kills
+// them all.
+type Grant struct {
+	ID       uuid.UUID
+	ClientID uuid.UUID
+	UserID   uuid.UUID
+	Scopes   []string
+	Revoked  bool
+}
+
+type AuthorizationCode struct {
+	CodeHash      string
+	GrantID       uuid.UUID
+	RedirectURI   string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	Used          bool
+}
+
+type RefreshToken struct {
+	TokenHash string
+	GrantID   uuid.UUID
+	ExpiresAt time.Time
+	Rotated   bool
+}
+
+// Store persists clients, grants, codes and refresh tokens. Lookups return
+// ErrNotFound when nothing matches.
+type Store interface {
+	GetClient(ctx context.Context, id uuid.UUID) (Client, error)
+
+	CreateGrant(ctx context.Context, grant Grant) error
+	GetGrant(ctx context.Context, id uuid.UUID) (Grant, error)
+	RevokeGrant(ctx context.Context, id uuid.UUID) error
+
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	GetAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error)
+	// UseAuthorizationCode marks a code used, reporting false if it already
+	// was.
+	UseAuthorizationCode(ctx context.Context, codeHash string) (bool, error)
+
+	CreateRefreshToken(ctx context.Context, token RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// RotateRefreshToken marks a token rotated, reporting false if it
+	// already was.
+	RotateRefreshToken(ctx context.Context, tokenHash string) (bool, error)
+}
+
+// Users signs a user in from the consent screen. otp is a TOTP or recovery
+// code and is only needed when Authenticate has asked for it with
+// ErrSecondFactorRequired. r is the consent form submission, for throttling
+// by client address.
+type Users interface {
+	Authenticate(r *http.Request, email, password, otp string) (uuid.UUID, error)
+}
+
+// TokenIssuer signs and verifies access tokens; *auth.KeySet is one.
+type TokenIssuer interface {
+	MakeClientJWT(userID uuid.UUID, clientID string, scopes []string, grantID uuid.UUID, expiresIn time.Duration) (string, error)
+	ValidateAccessToken(tokenString string) (auth.AccessClaims, error)
+	Issuer() string
+	Audience() string
+}
+
+type Server struct {
+	Store  Store
+	Users  Users
+	Tokens TokenIssuer
+	// Scopes maps each scope clients may request to the description shown
+	// on the consent screen.
+	Scopes map[string]string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	CodeTTL         time.Duration
+}
+
+func NewServer(store Store, users Users, tokens TokenIssuer, scopes map[string]string) *Server {
+	return &Server{
+		Store:           store,
+		Users:           users,
+		Tokens:          tokens,
+		Scopes:          scopes,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+		CodeTTL:         10 * time.Minute,
+	}
+}
+
+// NewSecret returns a random opaque value suitable for a client secret,
+// authorization code or refresh token. Only its auth.HashToken digest is
+// stored.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
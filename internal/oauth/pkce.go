@@ -0,0 +1,58 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Verifiers are 43-128 characters from the RFC 7636 unreserved set. A
+// base64url SHA-256 challenge is always 43 characters.
+var (
+	codeVerifierPattern  = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+	codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9\-_]{43}$`)
+)
+
+// S256Challenge derives the code_challenge a client sends for verifier.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func verifyPKCE(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(S256Challenge(verifier)), []byte(challenge)) == 1
+}
+
+// ValidateRedirectURI checks a redirect URI at client registration: it must
+// be absolute, have no fragment, and use https unless it points at the
+// loopback interface for a native app.
+func ValidateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect URI must be absolute")
+	}
+	if strings.Contains(raw, "#") {
+		return errors.New("redirect URI must not contain a fragment")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return nil
+		}
+		return errors.New("redirect URI must use https outside of localhost")
+	}
+	return errors.New("redirect URI must use https")
+}
@@ -0,0 +1,344 @@
+package oauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk0123456789"
+)
+
+type testUsers struct {
+	userID uuid.UUID
+	otp    string
+}
+
+func (u testUsers) Authenticate(r *http.Request, email, password, otp string) (uuid.UUID, error) {
+	if email != "walt@breakingbad.com" || password != "04234" {
+		return uuid.Nil, ErrInvalidCredentials
+	}
+	if u.otp != "" && otp != u.otp {
+		return uuid.Nil, ErrSecondFactorRequired
+	}
+	return u.userID, nil
+}
+
+type testEnv struct {
+	srv          *httptest.Server
+	keys         *auth.KeySet
+	store        *MemoryStore
+	client       Client
+	clientSecret string
+	userID       uuid.UUID
+}
+
+func newTestEnv(t *testing.T, users testUsers) *testEnv {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	keys := auth.NewKeySet("chirpy", "chirpy-api")
+	if err := keys.AddKey("test", priv); err != nil {
+		t.Fatalf("Error adding key: %s", err)
+	}
+	if err := keys.SetSigningKey("test"); err != nil {
+		t.Fatalf("Error setting signing key: %s", err)
+	}
+
+	store := NewMemoryStore()
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("Error generating secret: %s", err)
+	}
+	client := Client{ID: uuid.New(), Name: "Test App", SecretHash: auth.HashToken(secret), RedirectURIs: []string{testRedirectURI}}
+	store.AddClient(client)
+
+	if users.userID == uuid.Nil {
+		users.userID = uuid.New()
+	}
+	server := NewServer(store, users, keys, map[string]string{
+		"chirps:read":  "Read chirps",
+		"chirps:write": "Post and delete chirps",
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /oauth/authorize", server.HandleAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", server.HandleAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", server.HandleToken)
+	mux.HandleFunc("POST /oauth/introspect", server.HandleIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", server.HandleRevoke)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return &testEnv{srv: srv, keys: keys, store: store, client: client, clientSecret: secret, userID: users.userID}
+}
+
+func (e *testEnv) authorizeParams() url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {e.client.ID.String()},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"chirps:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {S256Challenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// noRedirects returns 302s to the test instead of following them to the
+// client's redirect URI.
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// authorize walks the consent screen and returns the code from the redirect.
+func (e *testEnv) authorize(t *testing.T, extra url.Values) string {
+	t.Helper()
+	params := e.authorizeParams()
+
+	resp, err := http.Get(e.srv.URL + "/oauth/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET /oauth/authorize: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Test App") {
+		t.Fatalf("expected consent screen, got %d: %s", resp.StatusCode, body)
+	}
+
+	params.Set("action", "approve")
+	params.Set("email", "walt@breakingbad.com")
+	params.Set("password", "04234")
+	for k, v := range extra {
+		params[k] = v
+	}
+	resp, err = noRedirects.PostForm(e.srv.URL+"/oauth/authorize", params)
+	if err != nil {
+		t.Fatalf("POST /oauth/authorize: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect after consent, got %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad Location: %s", err)
+	}
+	if got := loc.Scheme + "://" + loc.Host + loc.Path; got != testRedirectURI {
+		t.Fatalf("redirected to %s", got)
+	}
+	if loc.Query().Get("state") != "xyz" || loc.Query().Get("code") == "" {
+		t.Fatalf("unexpected redirect query: %s", loc.RawQuery)
+	}
+	return loc.Query().Get("code")
+}
+
+func (e *testEnv) post(t *testing.T, path string, form url.Values, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, e.srv.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Error building request: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(e.client.ID.String()), url.QueryEscape(e.clientSecret))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Error decoding %s response: %s", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (e *testEnv) exchange(t *testing.T, code string) (tokenResponse, int) {
+	t.Helper()
+	var tokens tokenResponse
+	status := e.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}, &tokens)
+	return tokens, status
+}
+
+func (e *testEnv) introspect(t *testing.T, token string) Introspection {
+	t.Helper()
+	var info Introspection
+	if status := e.post(t, "/oauth/introspect", url.Values{"token": {token}}, &info); status != http.StatusOK {
+		t.Fatalf("introspect returned %d", status)
+	}
+	return info
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+
+	code := e.authorize(t, nil)
+	tokens, status := e.exchange(t, code)
+	if status != http.StatusOK {
+		t.Fatalf("token exchange returned %d", status)
+	}
+	if tokens.TokenType != "Bearer" || tokens.Scope != "chirps:read" || tokens.RefreshToken == "" {
+		t.Errorf("unexpected token response: %+v", tokens)
+	}
+
+	claims, err := e.keys.ValidateAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("access token didn't validate: %s", err)
+	}
+	if claims.UserID != e.userID || claims.ClientID != e.client.ID.String() || !slices.Equal(claims.Scopes, []string{"chirps:read"}) {
+		t.Errorf("unexpected access token claims: %+v", claims)
+	}
+
+	info := e.introspect(t, tokens.AccessToken)
+	if !info.Active || info.Subject != e.userID.String() || info.TokenType != "access_token" || info.Scope != "chirps:read" {
+		t.Errorf("unexpected introspection: %+v", info)
+	}
+
+	// Refreshing rotates; the old refresh token is then dead.
+	var refreshed tokenResponse
+	if status := e.post(t, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}, &refreshed); status != http.StatusOK {
+		t.Fatalf("refresh returned %d", status)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+	if e.introspect(t, tokens.RefreshToken).Active {
+		t.Error("rotated refresh token is still active")
+	}
+
+	// Revoking the refresh token revokes the grant, and so the access token.
+	if status := e.post(t, "/oauth/revoke", url.Values{"token": {refreshed.RefreshToken}}, nil); status != http.StatusOK {
+		t.Fatalf("revoke returned %d", status)
+	}
+	if e.introspect(t, refreshed.AccessToken).Active {
+		t.Error("access token is still active after revocation")
+	}
+}
+
+func TestAuthorizationCodeReuseRevokesGrant(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+
+	code := e.authorize(t, nil)
+	tokens, status := e.exchange(t, code)
+	if status != http.StatusOK {
+		t.Fatalf("token exchange returned %d", status)
+	}
+	if _, status := e.exchange(t, code); status != http.StatusBadRequest {
+		t.Errorf("expected reused code to be rejected, got %d", status)
+	}
+	if e.introspect(t, tokens.AccessToken).Active {
+		t.Error("tokens from a reused code are still active")
+	}
+}
+
+func TestTokenExchangeRequiresPKCEVerifier(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+
+	code := e.authorize(t, nil)
+	status := e.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {strings.Repeat("a", 43)},
+	}, nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("expected wrong verifier to be rejected, got %d", status)
+	}
+}
+
+func TestTokenEndpointRejectsWrongClientSecret(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+	code := e.authorize(t, nil)
+
+	e.clientSecret = "wrong"
+	if _, status := e.exchange(t, code); status != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a bad client secret, got %d", status)
+	}
+}
+
+func TestAuthorizeAsksForSecondFactor(t *testing.T) {
+	e := newTestEnv(t, testUsers{otp: "123456"})
+
+	params := e.authorizeParams()
+	params.Set("action", "approve")
+	params.Set("email", "walt@breakingbad.com")
+	params.Set("password", "04234")
+	resp, err := noRedirects.PostForm(e.srv.URL+"/oauth/authorize", params)
+	if err != nil {
+		t.Fatalf("POST /oauth/authorize: %s", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(string(body), `name="otp"`) {
+		t.Fatalf("expected a two-factor prompt, got %d: %s", resp.StatusCode, body)
+	}
+
+	e.authorize(t, url.Values{"otp": {"123456"}})
+}
+
+func TestAuthorizeRejectsUnregisteredRedirectURI(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+
+	params := e.authorizeParams()
+	params.Set("redirect_uri", "https://evil.example.com/callback")
+	resp, err := noRedirects.Get(e.srv.URL + "/oauth/authorize?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET /oauth/authorize: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+		t.Errorf("expected an error page, got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestAuthorizeRedirectsDenial(t *testing.T) {
+	e := newTestEnv(t, testUsers{})
+
+	params := e.authorizeParams()
+	params.Set("action", "deny")
+	resp, err := noRedirects.PostForm(e.srv.URL+"/oauth/authorize", params)
+	if err != nil {
+		t.Fatalf("POST /oauth/authorize: %s", err)
+	}
+	resp.Body.Close()
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || loc.Query().Get("error") != "access_denied" || loc.Query().Get("state") != "xyz" {
+		t.Errorf("expected access_denied redirect, got %d to %s", resp.StatusCode, loc)
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://app.example.com/cb":   true,
+		"http://localhost:8080/cb":     true,
+		"http://127.0.0.1/cb":          true,
+		"http://app.example.com/cb":    false,
+		"https://app.example.com/cb#x": false,
+		"/cb":                          false,
+		"javascript:alert(1)":          false,
+	} {
+		if err := ValidateRedirectURI(raw); (err == nil) != ok {
+			t.Errorf("ValidateRedirectURI(%q) = %v", raw, err)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+// tokenError is an RFC 6749 section 5.2 error response.
+type tokenError struct {
+	status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *tokenError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func errInvalidGrant(description string) *tokenError {
+	return &tokenError{http.StatusBadRequest, "invalid_grant", description}
+}
+
+var errServer = &tokenError{http.StatusInternalServerError, "server_error", ""}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding OAuth response: %s", err)
+	}
+}
+
+func writeTokenError(w http.ResponseWriter, r *http.Request, err error) {
+	var tokenErr *tokenError
+	if !errors.As(err, &tokenErr) {
+		log.Printf("OAuth error: %s", err)
+		tokenErr = errServer
+	}
+	if tokenErr.status == http.StatusUnauthorized {
+		if _, _, ok := r.BasicAuth(); ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+	}
+	writeJSON(w, tokenErr.status, tokenErr)
+}
+
+// authenticateClient identifies the client calling the token, introspection
+// or revocation endpoint, by HTTP Basic auth or client_id and client_secret
+// form fields. Public clients send only their client_id.
+func (s *Server) authenticateClient(r *http.Request) (Client, error) {
+	invalid := &tokenError{http.StatusUnauthorized, "invalid_client", "Client authentication failed."}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		// RFC 6749 section 2.3.1 form-encodes both halves before encoding.
+		var err1, err2 error
+		id, err1 = url.QueryUnescape(id)
+		secret, err2 = url.QueryUnescape(secret)
+		if err1 != nil || err2 != nil {
+			return Client{}, invalid
+		}
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(id)
+	if err != nil {
+		return Client{}, invalid
+	}
+	client, err := s.Store.GetClient(r.Context(), clientID)
+	if errors.Is(err, ErrNotFound) {
+		return Client{}, invalid
+	}
+	if err != nil {
+		return Client{}, err
+	}
+
+	if client.Public() {
+		if secret != "" {
+			return Client{}, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return Client{}, invalid
+	}
+	return client, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// HandleToken is the token endpoint, POST /oauth/token. It supports the
+// authorization_code and refresh_token grants.
+func (s *Server) HandleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, r, &tokenError{http.StatusBadRequest, "invalid_request", "Couldn't parse form."})
+		return
+	}
+	client, err := s.authenticateClient(r)
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+
+	var resp tokenResponse
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		resp, err = s.exchangeAuthorizationCode(r.Context(), client, r.PostForm)
+	case "refresh_token":
+		resp, err = s.exchangeRefreshToken(r.Context(), client, r.PostForm)
+	default:
+		err = &tokenError{http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code and refresh_token are supported."}
+	}
+	if err != nil {
+		writeTokenError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) exchangeAuthorizationCode(ctx context.Context, client Client, form url.Values) (tokenResponse, error) {
+	codeHash := auth.HashToken(form.Get("code"))
+	code, err := s.Store.GetAuthorizationCode(ctx, codeHash)
+	if errors.Is(err, ErrNotFound) {
+		return tokenResponse{}, errInvalidGrant("Unknown authorization code.")
+	}
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	grant, err := s.Store.GetGrant(ctx, code.GrantID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if grant.ClientID != client.ID {
+		return tokenResponse{}, errInvalidGrant("Authorization code was issued to another client.")
+	}
+
+	// A code presented twice has leaked; whoever redeemed it first may not
+	// be the client, so everything issued from it is revoked (RFC 6749
+	// section 4.1.2).
+	if code.Used {
+		s.revokeGrant(ctx, grant.ID)
+		return tokenResponse{}, errInvalidGrant("Authorization code has already been used.")
+	}
+	if time.Now().UTC().After(code.ExpiresAt) {
+		return tokenResponse{}, errInvalidGrant("Authorization code has expired.")
+	}
+	if form.Get("redirect_uri") != code.RedirectURI {
+		return tokenResponse{}, errInvalidGrant("redirect_uri doesn't match the authorization request.")
+	}
+	if !verifyPKCE(form.Get("code_verifier"), code.CodeChallenge) {
+		return tokenResponse{}, errInvalidGrant("code_verifier doesn't match the code_challenge.")
+	}
+
+	first, err := s.Store.UseAuthorizationCode(ctx, codeHash)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if !first {
+		s.revokeGrant(ctx, grant.ID)
+		return tokenResponse{}, errInvalidGrant("Authorization code has already been used.")
+	}
+	if grant.Revoked {
+		return tokenResponse{}, errInvalidGrant("Authorization has been revoked.")
+	}
+
+	return s.issueTokens(ctx, grant, grant.Scopes)
+}
+
+func (s *Server) exchangeRefreshToken(ctx context.Context, client Client, form url.Values) (tokenResponse, error) {
+	tokenHash := auth.HashToken(form.Get("refresh_token"))
+	token, err := s.Store.GetRefreshToken(ctx, tokenHash)
+	if errors.Is(err, ErrNotFound) {
+		return tokenResponse{}, errInvalidGrant("Unknown refresh token.")
+	}
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	grant, err := s.Store.GetGrant(ctx, token.GrantID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if grant.ClientID != client.ID {
+		return tokenResponse{}, errInvalidGrant("Refresh token was issued to another client.")
+	}
+
+	// Same reuse detection as first-party refresh tokens: a rotated token
+	// coming back means two parties hold it.
+	if token.Rotated {
+		s.revokeGrant(ctx, grant.ID)
+		return tokenResponse{}, errInvalidGrant("Refresh token has already been used.")
+	}
+	if grant.Revoked || time.Now().UTC().After(token.ExpiresAt) {
+		return tokenResponse{}, errInvalidGrant("Refresh token is expired or has been revoked.")
+	}
+
+	// The client may ask for fewer scopes than it was granted, for this
+	// access token only (RFC 6749 section 6).
+	scopes := grant.Scopes
+	if requested := strings.Fields(form.Get("scope")); len(requested) > 0 {
+		for _, scope := range requested {
+			if !slices.Contains(grant.Scopes, scope) {
+				return tokenResponse{}, &tokenError{http.StatusBadRequest, "invalid_scope", "Scope " + scope + " was not granted."}
+			}
+		}
+		scopes = requested
+	}
+
+	rotated, err := s.Store.RotateRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if !rotated {
+		s.revokeGrant(ctx, grant.ID)
+		return tokenResponse{}, errInvalidGrant("Refresh token has already been used.")
+	}
+
+	return s.issueTokens(ctx, grant, scopes)
+}
+
+func (s *Server) issueTokens(ctx context.Context, grant Grant, scopes []string) (tokenResponse, error) {
+	accessToken, err := s.Tokens.MakeClientJWT(grant.UserID, grant.ClientID.String(), scopes, grant.ID, s.AccessTokenTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	refreshToken, err := NewSecret()
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	err = s.Store.CreateRefreshToken(ctx, RefreshToken{
+		TokenHash: auth.HashToken(refreshToken),
+		GrantID:   grant.ID,
+		ExpiresAt: time.Now().UTC().Add(s.RefreshTokenTTL),
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+func (s *Server) revokeGrant(ctx context.Context, id uuid.UUID) {
+	if err := s.Store.RevokeGrant(ctx, id); err != nil {
+		log.Printf("Error revoking OAuth grant %s: %s", id, err)
+	}
+}
@@ -0,0 +1,331 @@
+// Package oidc signs users in with an external OpenID Connect provider: it
+// fetches the provider's discovery document, runs the authorization code
+// flow with PKCE and verifies ID tokens against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefreshInterval bounds how often an unknown kid may trigger a JWKS
+// refetch, so a flood of forged tokens can't hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+// signingAlgs are the ID token algorithms accepted. "none" and HMAC are
+// never accepted.
+var signingAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type Config struct {
+	// Name identifies the provider in routes and in stored identities.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes defaults to openid, email and profile.
+	Scopes []string
+}
+
+// Metadata is the part of the discovery document Chirpy uses.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is what a verified ID token says about the user.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *Metadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// NewProvider returns a provider that fetches its discovery document on
+// first use, so Chirpy can start while the provider is unreachable.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Metadata fetches and caches the provider's discovery document.
+func (p *Provider) Metadata(ctx context.Context) (Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return *p.metadata, nil
+	}
+
+	var md Metadata
+	discovery := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discovery, &md); err != nil {
+		return Metadata{}, fmt.Errorf("oidc discovery for %s: %w", p.cfg.Name, err)
+	}
+	// OIDC Discovery section 4.3: the document must be for the issuer we
+	// asked about.
+	if md.Issuer != p.cfg.Issuer {
+		return Metadata{}, fmt.Errorf("oidc discovery for %s: issuer %q doesn't match %q", p.cfg.Name, md.Issuer, p.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return Metadata{}, fmt.Errorf("oidc discovery for %s: document is missing endpoints", p.cfg.Name)
+	}
+	p.metadata = &md
+	return md, nil
+}
+
+// AuthCodeURL is where to send the user to sign in. state and nonce are
+// random values the caller keeps to check the callback and ID token against;
+// codeVerifier is the PKCE secret later passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", s256Challenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token. It
+// is not verified yet; pass it to VerifyIDToken.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc token response from %s: %w", p.cfg.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token request to %s: %s: %s %s", p.cfg.Name, resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("oidc token response from %s has no id_token", p.cfg.Name)
+	}
+	return body.IDToken, nil
+}
+
+type idTokenClaims struct {
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   any    `json:"email_verified"`
+	Name            string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS
+// and its iss, aud, azp, exp and nonce claims (OIDC Core section 3.1.3.7).
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (Identity, error) {
+	md, err := p.Metadata(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	claims := idTokenClaims{}
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, md.JWKSURI, kid)
+		if err != nil {
+			return nil, err
+		}
+		if !keyMatchesMethod(key, token.Method) {
+			return nil, fmt.Errorf("key %q can't verify %s", kid, token.Method.Alg())
+		}
+		return key, nil
+	}
+	_, err = jwt.ParseWithClaims(rawIDToken, &claims, keyFunc,
+		jwt.WithValidMethods(signingAlgs),
+		jwt.WithIssuer(md.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if (claims.AuthorizedParty != "" || len(claims.Audience) > 1) && claims.AuthorizedParty != p.cfg.ClientID {
+		return Identity{}, errors.New("id token azp doesn't name this client")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Identity{}, errors.New("id token nonce doesn't match")
+	}
+	if claims.Subject == "" {
+		return Identity{}, errors.New("id token has no sub")
+	}
+
+	return Identity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   strings.ToLower(strings.TrimSpace(claims.Email)),
+		// Some providers send "true" as a string.
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          claims.Name,
+	}, nil
+}
+
+// key returns the provider's key for kid, refetching the JWKS when kid is
+// unknown so that key rotation at the provider is picked up.
+func (p *Provider) key(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	var set auth.JWKS
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching jwks for %s: %w", p.cfg.Name, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			// One key we can't read shouldn't lock out the rest.
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func keyMatchesMethod(key crypto.PublicKey, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, rs := method.(*jwt.SigningMethodRSA)
+		_, ps := method.(*jwt.SigningMethodRSAPSS)
+		return rs || ps
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+// RandomValue returns a random URL-safe string for state, nonce or a PKCE
+// code verifier.
+func RandomValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,250 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "chirpy"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://chirpy.example.com/api/auth/oidc/mock/callback"
+)
+
+// mockProvider is a minimal OpenID provider: discovery, JWKS, an authorize
+// endpoint that approves immediately and a token endpoint that checks PKCE.
+type mockProvider struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+	// claims lets a test tamper with the ID token before it is signed.
+	claims func(jwt.MapClaims)
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	m := &mockProvider{t: t, key: key, codes: map[string]url.Values{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Metadata{
+			Issuer:                m.srv.URL,
+			AuthorizationEndpoint: m.srv.URL + "/authorize",
+			TokenEndpoint:         m.srv.URL + "/token",
+			JWKSURI:               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.JWKS{Keys: []auth.JWK{{
+			KeyType:   "RSA",
+			KeyID:     "mock-1",
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code, _ := RandomValue()
+		m.mu.Lock()
+		m.codes[code] = q
+		m.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		m.mu.Lock()
+		req, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mu.Unlock()
+		if id != testClientID || secret != testClientSecret || !ok ||
+			s256Challenge(r.PostForm.Get("code_verifier")) != req.Get("code_challenge") ||
+			r.PostForm.Get("redirect_uri") != req.Get("redirect_uri") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken(req.Get("nonce")), "token_type": "Bearer"})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(nonce string) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.srv.URL,
+		"sub":            "mock-user-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "Walt@BreakingBad.com",
+		"email_verified": true,
+	}
+	if m.claims != nil {
+		m.claims(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock-1"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("Error signing id token: %s", err)
+	}
+	return signed
+}
+
+func (m *mockProvider) provider() *Provider {
+	return NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	}, m.srv.Client())
+}
+
+// login runs the browser's part of the flow and returns the ID token.
+func (m *mockProvider) login(t *testing.T, p *Provider, nonce string) string {
+	t.Helper()
+	ctx := context.Background()
+	state, _ := RandomValue()
+	verifier, _ := RandomValue()
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %s", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET %s: %s", authURL, err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad Location: %s", err)
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("state not returned: %s", callback)
+	}
+
+	rawIDToken, err := p.Exchange(ctx, callback.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatalf("Exchange returned error: %s", err)
+	}
+	return rawIDToken
+}
+
+func TestLoginFlowAgainstMockProvider(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	rawIDToken := m.login(t, p, "nonce-1")
+	identity, err := p.VerifyIDToken(context.Background(), rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken returned error: %s", err)
+	}
+	want := Identity{Issuer: m.srv.URL, Subject: "mock-user-1", Email: "walt@breakingbad.com", EmailVerified: true}
+	if identity != want {
+		t.Errorf("got identity %+v, want %+v", identity, want)
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier-one-verifier-one-verifier-one-abcd")
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %s", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET %s: %s", authURL, err)
+	}
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	if _, err := p.Exchange(context.Background(), callback.Query().Get("code"), "verifier-two-verifier-two-verifier-two-abcd"); err == nil {
+		t.Error("expected exchange with the wrong verifier to fail")
+	}
+}
+
+func TestVerifyIDTokenRejectsBadTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		tamper func(jwt.MapClaims)
+	}{
+		{name: "wrong nonce", nonce: "other"},
+		{name: "wrong audience", nonce: "n", tamper: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "wrong issuer", nonce: "n", tamper: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", nonce: "n", tamper: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "foreign azp", nonce: "n", tamper: func(c jwt.MapClaims) { c["azp"] = "someone-else" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.tamper
+			p := m.provider()
+			if _, err := p.VerifyIDToken(context.Background(), m.idToken("n"), tt.nonce); err == nil {
+				t.Error("expected VerifyIDToken to fail")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsHMACTokens(t *testing.T) {
+	m := newMockProvider(t)
+	p := m.provider()
+
+	// Signed with the public modulus as an HMAC secret: the classic
+	// algorithm confusion attack.
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss": m.srv.URL, "sub": "x", "aud": testClientID, "nonce": "n",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "mock-1"
+	signed, err := token.SignedString(m.key.N.Bytes())
+	if err != nil {
+		t.Fatalf("Error signing token: %s", err)
+	}
+	if _, err := p.VerifyIDToken(context.Background(), signed, "n"); err == nil {
+		t.Error("expected an HS256 id token to be rejected")
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	m := newMockProvider(t)
+	// Same discovery URL, but not the issuer the document names.
+	p := NewProvider(Config{Name: "mock", Issuer: m.srv.URL + "/", ClientID: testClientID}, m.srv.Client())
+
+	if _, err := p.Metadata(context.Background()); err == nil {
+		t.Error("expected discovery to fail for a mismatched issuer")
+	}
+}
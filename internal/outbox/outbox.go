@@ -0,0 +1,90 @@
+// Package outbox carries messages written to the outbox table, in the same
+// transaction as the change they describe, to whoever acts on them.
+//
+// Delivery is at least once: a message is published again if publishing it
+// failed or the relay died before recording that it succeeded, so handlers
+// must tolerate seeing a message twice. Messages about the same aggregate
+// are published in the order they were written.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	firstRetryDelay = 5 * time.Second
+	maxRetryDelay   = 10 * time.Minute
+)
+
+// Message is one row of the outbox.
+type Message struct {
+	ID int64
+	// AggregateType and AggregateID name what the message is about, such as
+	// a chirp or a user's membership.
+	AggregateType string
+	AggregateID   string
+	Type          string
+	Payload       []byte
+	CreatedAt     time.Time
+}
+
+// Publisher sends messages on. A message broker client would implement it;
+// Bus does for subscribers in this process.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Handler acts on a message. Returning an error has the message published
+// again later.
+type Handler func(ctx context.Context, msg Message) error
+
+// Bus publishes messages to handlers subscribed to their type in this
+// process. A message nobody subscribes to is published successfully.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe has handler called for every message of type msgType.
+func (b *Bus) Subscribe(msgType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[msgType] = append(b.handlers[msgType], handler)
+}
+
+// Publish calls every handler for the message's type, even if some fail.
+// If any fails, the message will be published again to all of them.
+func (b *Bus) Publish(ctx context.Context, msg Message) error {
+	b.mu.RLock()
+	handlers := b.handlers[msg.Type]
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("outbox: publishing %s %d: %w", msg.Type, msg.ID, err)
+	}
+	return nil
+}
+
+// RetryDelay is how long to wait before publishing a message again after
+// attempts failures: 5s, doubling each time, up to 10m.
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
@@ -0,0 +1,71 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBusPublishesToSubscribersOfType(t *testing.T) {
+	bus := NewBus()
+	var got []string
+	bus.Subscribe("chirp.created", func(ctx context.Context, msg Message) error {
+		got = append(got, "first:"+msg.AggregateID)
+		return nil
+	})
+	bus.Subscribe("chirp.created", func(ctx context.Context, msg Message) error {
+		got = append(got, "second:"+msg.AggregateID)
+		return nil
+	})
+	bus.Subscribe("chirp.deleted", func(ctx context.Context, msg Message) error {
+		got = append(got, "deleted")
+		return nil
+	})
+
+	if err := bus.Publish(context.Background(), Message{ID: 1, Type: "chirp.created", AggregateID: "c1"}); err != nil {
+		t.Fatalf("Publish returned error: %s", err)
+	}
+	if len(got) != 2 || got[0] != "first:c1" || got[1] != "second:c1" {
+		t.Errorf("unexpected handler calls %v", got)
+	}
+
+	if err := bus.Publish(context.Background(), Message{ID: 2, Type: "user.created"}); err != nil {
+		t.Errorf("expected a message with no subscribers to succeed, got %s", err)
+	}
+}
+
+func TestBusReportsFailingHandlers(t *testing.T) {
+	bus := NewBus()
+	failure := errors.New("mail server down")
+	called := false
+	bus.Subscribe("membership.changed", func(ctx context.Context, msg Message) error {
+		return failure
+	})
+	bus.Subscribe("membership.changed", func(ctx context.Context, msg Message) error {
+		called = true
+		return nil
+	})
+
+	err := bus.Publish(context.Background(), Message{ID: 3, Type: "membership.changed"})
+	if !errors.Is(err, failure) {
+		t.Errorf("expected the handler's error, got %v", err)
+	}
+	if !called {
+		t.Error("expected the other handler to run despite the failure")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		7:  320 * time.Second,
+		8:  10 * time.Minute,
+		30: 10 * time.Minute,
+	} {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
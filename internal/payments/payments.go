@@ -0,0 +1,68 @@
+// Package payments turns webhooks from payment providers into membership
+// events in Chirpy's own terms, so the rest of the server doesn't care who
+// took the money.
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Canonical membership events.
+const (
+	// MembershipStarted is a new paid period for someone who wasn't a
+	// member, or whose membership had ended.
+	MembershipStarted = "membership.started"
+	// MembershipRenewed is a further paid period for a member.
+	MembershipRenewed = "membership.renewed"
+	// MembershipCanceled means the membership won't renew but runs to the
+	// end of the period paid for.
+	MembershipCanceled = "membership.canceled"
+	// PaymentFailed means a renewal couldn't be charged; the provider will
+	// retry.
+	PaymentFailed = "payment.failed"
+	// MembershipEnded ends a membership now.
+	MembershipEnded = "membership.ended"
+)
+
+var (
+	// ErrMalformedEvent means a webhook was genuine but couldn't be
+	// understood.
+	ErrMalformedEvent = errors.New("payments: malformed event")
+	// ErrNotConfigured means a provider has no webhook secret.
+	ErrNotConfigured = errors.New("payments: provider not configured")
+)
+
+// Event is a webhook from a provider, parsed.
+type Event struct {
+	// ID is the provider's ID for the event, unique per provider.
+	ID string
+	// Type is one of the canonical events above, or "" for events that
+	// don't change a membership.
+	Type string
+	// ProviderType is what the provider called it.
+	ProviderType string
+	// CustomerID is the provider's ID for the paying customer, if any.
+	CustomerID string
+	// UserID is the Chirpy user the event is for, when the provider tells
+	// us. Providers that don't may still give a CustomerID that has been
+	// linked to a user by an earlier event.
+	UserID uuid.UUID
+	// PeriodEnd is when a new or renewed period ends, if the provider says.
+	PeriodEnd time.Time
+}
+
+// Provider is a payment provider that tells us about memberships by
+// webhook.
+type Provider interface {
+	// Name identifies the provider in webhook URLs and stored records.
+	Name() string
+	// VerifyWebhook checks that a webhook really came from the provider.
+	VerifyWebhook(header http.Header, body []byte, now time.Time) error
+	// ParseEvent reads a verified webhook body. It returns an error
+	// wrapping ErrMalformedEvent if the body can't be understood.
+	ParseEvent(body []byte) (Event, error)
+}
@@ -0,0 +1,110 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+func TestProvidersVerifyTheirOwnHeader(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	polka, _ := NewPolka("polka-secret")
+	stripe, _ := NewStripe("stripe-secret")
+
+	for _, tc := range []struct {
+		provider Provider
+		header   string
+		secret   string
+	}{
+		{polka, PolkaSignatureHeader, "polka-secret"},
+		{stripe, StripeSignatureHeader, "stripe-secret"},
+	} {
+		header := http.Header{}
+		header.Set(tc.header, webhook.Sign(tc.secret, now, body))
+		if err := tc.provider.VerifyWebhook(header, body, now); err != nil {
+			t.Errorf("%s: expected a valid signature, got %s", tc.provider.Name(), err)
+		}
+
+		header = http.Header{}
+		header.Set(tc.header, webhook.Sign("wrong", now, body))
+		if err := tc.provider.VerifyWebhook(header, body, now); err == nil {
+			t.Errorf("%s: expected a signature under the wrong secret to fail", tc.provider.Name())
+		}
+	}
+}
+
+func TestProvidersNeedASecret(t *testing.T) {
+	if _, err := NewPolka(""); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured for polka, got %v", err)
+	}
+	if _, err := NewStripe(""); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("expected ErrNotConfigured for stripe, got %v", err)
+	}
+}
+
+func TestPolkaParseEvent(t *testing.T) {
+	polka, _ := NewPolka("secret")
+	userID := uuid.New()
+
+	event, err := polka.ParseEvent([]byte(`{"id":"evt_1","event":"subscription.renewed","data":{"user_id":"` + userID.String() + `","period_end":"2026-01-01T00:00:00Z"}}`))
+	if err != nil {
+		t.Fatalf("ParseEvent returned error: %s", err)
+	}
+	if event.Type != MembershipRenewed || event.UserID != userID || event.CustomerID != userID.String() ||
+		!event.PeriodEnd.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	event, err = polka.ParseEvent([]byte(`{"id":"evt_2","event":"user.renamed","data":{}}`))
+	if err != nil || event.Type != "" || event.ProviderType != "user.renamed" {
+		t.Errorf("expected an unknown event to parse with no type, got %+v, %v", event, err)
+	}
+
+	for _, body := range []string{`{"event":"user.upgraded"}`, `not json`} {
+		if _, err := polka.ParseEvent([]byte(body)); !errors.Is(err, ErrMalformedEvent) {
+			t.Errorf("expected ErrMalformedEvent for %s, got %v", body, err)
+		}
+	}
+}
+
+func TestStripeParseEvent(t *testing.T) {
+	stripe, _ := NewStripe("secret")
+	userID := uuid.New()
+
+	for _, tc := range []struct {
+		body       string
+		wantType   string
+		wantUser   uuid.UUID
+		wantPeriod int64
+	}{
+		{`{"id":"evt_1","type":"checkout.session.completed","data":{"object":{"customer":"cus_1","client_reference_id":"` + userID.String() + `"}}}`, "", userID, 0},
+		{`{"id":"evt_2","type":"customer.subscription.created","data":{"object":{"customer":"cus_1","current_period_end":1767225600}}}`, MembershipStarted, uuid.Nil, 1767225600},
+		{`{"id":"evt_3","type":"customer.subscription.updated","data":{"object":{"customer":"cus_1","cancel_at_period_end":true,"metadata":{"user_id":"` + userID.String() + `"}}}}`, MembershipCanceled, userID, 0},
+		{`{"id":"evt_4","type":"customer.subscription.updated","data":{"object":{"customer":"cus_1"}}}`, "", uuid.Nil, 0},
+		{`{"id":"evt_5","type":"invoice.paid","data":{"object":{"customer":"cus_1","lines":{"data":[{"period":{"end":1769904000}}]}}}}`, MembershipRenewed, uuid.Nil, 1769904000},
+		{`{"id":"evt_9","type":"invoice.paid","data":{"object":{"customer":"cus_1","billing_reason":"subscription_create","lines":{"data":[{"period":{"end":1767225600}}]}}}}`, "", uuid.Nil, 0},
+		{`{"id":"evt_6","type":"invoice.payment_failed","data":{"object":{"customer":"cus_1"}}}`, PaymentFailed, uuid.Nil, 0},
+		{`{"id":"evt_7","type":"customer.subscription.deleted","data":{"object":{"customer":"cus_1"}}}`, MembershipEnded, uuid.Nil, 0},
+	} {
+		event, err := stripe.ParseEvent([]byte(tc.body))
+		if err != nil {
+			t.Errorf("ParseEvent(%s) returned error: %s", tc.body, err)
+			continue
+		}
+		if event.Type != tc.wantType || event.UserID != tc.wantUser || event.CustomerID != "cus_1" {
+			t.Errorf("ParseEvent(%s) = %+v", tc.body, event)
+		}
+		if tc.wantPeriod != 0 && !event.PeriodEnd.Equal(time.Unix(tc.wantPeriod, 0)) {
+			t.Errorf("ParseEvent(%s) period end = %s", tc.body, event.PeriodEnd)
+		}
+	}
+
+	if _, err := stripe.ParseEvent([]byte(`{"id":"evt_8","type":"invoice.paid","data":{"object":{"metadata":{"user_id":"someone"}}}}`)); !errors.Is(err, ErrMalformedEvent) {
+		t.Errorf("expected ErrMalformedEvent for a bad user reference, got %v", err)
+	}
+}
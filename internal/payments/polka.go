@@ -0,0 +1,70 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// PolkaSignatureHeader carries Polka's signature; see package webhook.
+const PolkaSignatureHeader = "Polka-Signature"
+
+var polkaEventTypes = map[string]string{
+	"user.upgraded":         MembershipStarted,
+	"subscription.renewed":  MembershipRenewed,
+	"subscription.canceled": MembershipCanceled,
+	"payment.failed":        PaymentFailed,
+	"user.downgraded":       MembershipEnded,
+}
+
+// Polka knows our users by their Chirpy ID, so it is its own customer
+// mapping.
+type Polka struct {
+	secret string
+}
+
+func NewPolka(secret string) (*Polka, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("%w: polka needs a webhook secret", ErrNotConfigured)
+	}
+	return &Polka{secret: secret}, nil
+}
+
+func (p *Polka) Name() string { return "polka" }
+
+func (p *Polka) VerifyWebhook(header http.Header, body []byte, now time.Time) error {
+	return webhook.Verify(p.secret, header.Get(PolkaSignatureHeader), body, webhook.DefaultTolerance, now)
+}
+
+func (p *Polka) ParseEvent(body []byte) (Event, error) {
+	var payload struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  struct {
+			UserID    uuid.UUID `json:"user_id"`
+			PeriodEnd time.Time `json:"period_end"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	if payload.ID == "" {
+		return Event{}, fmt.Errorf("%w: event id is required", ErrMalformedEvent)
+	}
+
+	event := Event{
+		ID:           payload.ID,
+		Type:         polkaEventTypes[payload.Event],
+		ProviderType: payload.Event,
+		UserID:       payload.Data.UserID,
+		PeriodEnd:    payload.Data.PeriodEnd,
+	}
+	if event.UserID != uuid.Nil {
+		event.CustomerID = event.UserID.String()
+	}
+	return event, nil
+}
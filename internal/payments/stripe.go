@@ -0,0 +1,123 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// StripeSignatureHeader carries a Stripe-style signature, which is the same
+// t=...,v1=... format package webhook uses.
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Stripe handles Stripe-style subscription webhooks. Stripe identifies
+// customers by its own IDs; checkout sessions link one to a Chirpy user
+// through client_reference_id, and subscriptions may carry a user_id in
+// their metadata.
+type Stripe struct {
+	secret string
+}
+
+func NewStripe(secret string) (*Stripe, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("%w: stripe needs a webhook secret", ErrNotConfigured)
+	}
+	return &Stripe{secret: secret}, nil
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+func (s *Stripe) VerifyWebhook(header http.Header, body []byte, now time.Time) error {
+	return webhook.Verify(s.secret, header.Get(StripeSignatureHeader), body, webhook.DefaultTolerance, now)
+}
+
+// stripeObject is the union of the fields we read from the objects Stripe
+// events carry: checkout sessions, subscriptions and invoices.
+type stripeObject struct {
+	Customer          string            `json:"customer"`
+	ClientReferenceID string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+	CurrentPeriodEnd  int64             `json:"current_period_end"`
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end"`
+	BillingReason     string            `json:"billing_reason"`
+	Lines             struct {
+		Data []struct {
+			Period struct {
+				End int64 `json:"end"`
+			} `json:"period"`
+		} `json:"data"`
+	} `json:"lines"`
+}
+
+func (s *Stripe) ParseEvent(body []byte) (Event, error) {
+	var payload struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object stripeObject `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, fmt.Errorf("%w: %s", ErrMalformedEvent, err)
+	}
+	if payload.ID == "" {
+		return Event{}, fmt.Errorf("%w: event id is required", ErrMalformedEvent)
+	}
+	object := payload.Data.Object
+
+	event := Event{
+		ID:           payload.ID,
+		ProviderType: payload.Type,
+		CustomerID:   object.Customer,
+	}
+
+	userRef := object.Metadata["user_id"]
+	if object.ClientReferenceID != "" {
+		userRef = object.ClientReferenceID
+	}
+	if userRef != "" {
+		userID, err := uuid.Parse(userRef)
+		if err != nil {
+			return Event{}, fmt.Errorf("%w: invalid user reference %q", ErrMalformedEvent, userRef)
+		}
+		event.UserID = userID
+	}
+
+	switch payload.Type {
+	case "customer.subscription.created":
+		event.Type = MembershipStarted
+		event.PeriodEnd = unixTime(object.CurrentPeriodEnd)
+	case "customer.subscription.updated":
+		// Only a pending cancellation matters; other updates are
+		// followed by an invoice event.
+		if object.CancelAtPeriodEnd {
+			event.Type = MembershipCanceled
+		}
+	case "invoice.paid":
+		// The first invoice pays for the period the subscription started
+		// with; customer.subscription.created has already reported it.
+		if object.BillingReason == "subscription_create" {
+			break
+		}
+		event.Type = MembershipRenewed
+		if len(object.Lines.Data) > 0 {
+			event.PeriodEnd = unixTime(object.Lines.Data[0].Period.End)
+		}
+	case "invoice.payment_failed":
+		event.Type = PaymentFailed
+	case "customer.subscription.deleted":
+		event.Type = MembershipEnded
+	}
+	return event, nil
+}
+
+func unixTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t, 0).UTC()
+}
@@ -0,0 +1,113 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// SecretPrefix marks webhook signing secrets, like the "chirpy_pat_" prefix
+// on personal access tokens.
+const SecretPrefix = "whsec_"
+
+const (
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+)
+
+// ErrForbiddenAddress is returned when a webhook URL resolves to an address
+// on a private network, which deliveries must never reach.
+var ErrForbiddenAddress = errors.New("webhook: destination address not allowed")
+
+// NewSecret returns a random signing secret for a webhook endpoint.
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return SecretPrefix + hex.EncodeToString(secret), nil
+}
+
+// RetryDelay is how long to wait before retrying a delivery that has failed
+// attempts times: 30s, doubling each time, up to 6h.
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// NewClient returns the HTTP client deliveries are sent with. Unless
+// allowPrivate is set, it refuses to connect to loopback, private,
+// link-local and other non-public addresses, so a webhook URL can't be used
+// to reach services inside our network. The check is made on the address
+// actually dialled, so DNS tricks don't get around it.
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Proxies would dial on our behalf, past the check.
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect is a failed delivery, not somewhere else to post to.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// reservedNets are special-purpose ranges the net.IP predicates don't cover
+// but that can still reach inside a network.
+var reservedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",     // "this network"
+		"100.64.0.0/10", // carrier-grade NAT
+		"198.18.0.0/15", // benchmarking
+		"240.0.0.0/4",   // reserved, and broadcast
+		"64:ff9b::/96",  // NAT64, which maps onto any IPv4 address
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range reservedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		50: 6 * time.Hour,
+	} {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret returned error: %s", err)
+	}
+	b, _ := NewSecret()
+	if !strings.HasPrefix(a, SecretPrefix) || a == b {
+		t.Errorf("expected distinct prefixed secrets, got %q and %q", a, b)
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second, false).Post(server.URL, "application/json", nil)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("expected ErrForbiddenAddress for a loopback URL, got %v", err)
+	}
+
+	resp, err := NewClient(time.Second, true).Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("expected loopback to be allowed with allowPrivate, got %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":      true,
+		"2606:2800:220:1::1": true,
+		"127.0.0.1":          false,
+		"10.1.2.3":           false,
+		"169.254.169.254":    false,
+		"0.1.2.3":            false,
+		"100.64.0.1":         false,
+		"100.127.255.254":    false,
+		"198.19.0.1":         false,
+		"255.255.255.255":    false,
+		"::ffff:100.64.0.1":  false,
+		"64:ff9b::a9fe:a9fe": false,
+		"64:ff9b::5db8:d822": false,
+		"fd00::1":            false,
+	} {
+		if got := isPublic(net.ParseIP(addr)); got != want {
+			t.Errorf("isPublic(%s) = %t, want %t", addr, got, want)
+		}
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	resp, err := NewClient(time.Second, true).Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("Post returned error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("expected the redirect itself, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+// Package webhook signs and verifies webhook payloads with HMAC-SHA256, and
+// sends them.
+//
+// A signature header looks like
+//
+//	t=1700000000,v1=5257a869e7...
+//
+// where t is the Unix time the payload was signed and each v1 is the hex
+// HMAC-SHA256 of "<t>.<body>" under a shared secret. Several v1 values may be
+// given while a secret is being rotated; any one matching is enough.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTolerance is how far a signature's timestamp may be from now before
+// it is refused as a replay.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMalformedSignature = errors.New("webhook: malformed signature header")
+	ErrNoValidSignature   = errors.New("webhook: no valid signature")
+	ErrTimestampExpired   = errors.New("webhook: timestamp outside tolerance")
+)
+
+func computeSignature(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Sign returns the signature header for body, signed at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := t.Unix()
+	return "t=" + strconv.FormatInt(ts, 10) + ",v1=" + hex.EncodeToString(computeSignature(secret, ts, body))
+}
+
+// Verify checks a signature header against body. The timestamp must be
+// within tolerance of now, so a captured request can't be replayed later.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var (
+		timestamp  int64
+		haveTime   bool
+		signatures [][]byte
+	)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return ErrMalformedSignature
+		}
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrMalformedSignature
+			}
+			timestamp, haveTime = ts, true
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrMalformedSignature
+			}
+			signatures = append(signatures, sig)
+		}
+		// Other schemes are ignored, so new ones can be added later.
+	}
+	if !haveTime || len(signatures) == 0 {
+		return ErrMalformedSignature
+	}
+
+	if d := now.Sub(time.Unix(timestamp, 0)); d > tolerance || d < -tolerance {
+		return ErrTimestampExpired
+	}
+
+	expected := computeSignature(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrNoValidSignature
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "whsec_test"
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded"}`)
+	header := Sign(testSecret, now, body)
+
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Errorf("unexpected header %q", header)
+	}
+	if err := Verify(testSecret, header, body, DefaultTolerance, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected signature to verify, got %s", err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"user.upgraded"}`)
+	valid := Sign(testSecret, now, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   string
+		now    time.Time
+		want   error
+	}{
+		{"tampered body", testSecret, valid, `{"event":"user.downgraded"}`, now, ErrNoValidSignature},
+		{"wrong secret", "other", valid, string(body), now, ErrNoValidSignature},
+		{"stale", testSecret, valid, string(body), now.Add(DefaultTolerance + time.Second), ErrTimestampExpired},
+		{"from the future", testSecret, valid, string(body), now.Add(-DefaultTolerance - time.Second), ErrTimestampExpired},
+		{"empty header", testSecret, "", string(body), now, ErrMalformedSignature},
+		{"no timestamp", testSecret, "v1=abcd", string(body), now, ErrMalformedSignature},
+		{"no signature", testSecret, "t=1700000000", string(body), now, ErrMalformedSignature},
+		{"bad hex", testSecret, "t=1700000000,v1=zz", string(body), now, ErrMalformedSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, []byte(tt.body), DefaultTolerance, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyAcceptsAnyOfSeveralSignatures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{}`)
+	rotated := Sign("old-secret", now, body)
+	_, oldSig, _ := strings.Cut(rotated, ",")
+	header := Sign(testSecret, now, body) + "," + oldSig
+
+	for _, secret := range []string{testSecret, "old-secret"} {
+		if err := Verify(secret, header, body, DefaultTolerance, now); err != nil {
+			t.Errorf("expected %s to verify, got %s", secret, err)
+		}
+	}
+}
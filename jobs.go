@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultJobLimit = 100
+	maxJobLimit     = 1000
+	// jobPurgeBatch bounds each delete, so purging a large backlog doesn't
+	// hold locks on the whole table at once.
+	jobPurgeBatch = 1000
+	// dataExportTimeout bounds building one export, the slowest job.
+	dataExportTimeout = 10 * time.Minute
+	// jobLease outlasts the slowest job, so one still running isn't claimed
+	// again by another worker.
+	jobLease = dataExportTimeout + 5*time.Minute
+)
+
+type dataExportJob struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+var (
+	purgeAccountsJob          = jobs.NewKind[struct{}]("accounts.purge")
+	expireMembershipsJob      = jobs.NewKind[struct{}]("memberships.expire")
+	purgeLoginThrottlesJob    = jobs.NewKind[struct{}]("login_throttles.purge")
+	purgeWebhookDeliveriesJob = jobs.NewKind[struct{}]("webhook_deliveries.purge")
+	purgeTokensJob            = jobs.NewKind[struct{}]("refresh_tokens.purge")
+	purgeJobsJob              = jobs.NewKind[struct{}]("jobs.purge")
+	buildDataExportJob        = jobs.NewKind[dataExportJob]("data_export.build")
+)
+
+// jobStore keeps the job queue in the jobs table. Built on a transaction's
+// queries, it enqueues jobs that only run if the transaction commits.
+type jobStore struct {
+	db *database.Queries
+}
+
+func (s jobStore) Enqueue(ctx context.Context, job jobs.NewJob) (uuid.UUID, error) {
+	row, err := s.db.EnqueueJob(ctx, database.EnqueueJobParams{
+		Kind:      job.Kind,
+		Payload:   string(job.Payload),
+		RunAt:     job.RunAt.UTC(),
+		UniqueKey: sql.NullString{String: job.UniqueKey, Valid: job.UniqueKey != ""},
+	})
+	// ON CONFLICT DO NOTHING returns no row for a duplicate unique key.
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, jobs.ErrDuplicate
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return row.ID, nil
+}
+
+func (s jobStore) Claim(ctx context.Context, kinds []string, limit int, leaseUntil time.Time) ([]jobs.Job, error) {
+	rows, err := s.db.ClaimJobs(ctx, database.ClaimJobsParams{
+		LeaseUntil: sql.NullTime{Time: leaseUntil, Valid: true},
+		Kinds:      kinds,
+		MaxJobs:    int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	claimed := make([]jobs.Job, 0, len(rows))
+	for _, row := range rows {
+		claimed = append(claimed, jobs.Job{
+			ID:       row.ID,
+			Kind:     row.Kind,
+			Payload:  []byte(row.Payload),
+			Attempts: int(row.Attempts),
+		})
+	}
+	return claimed, nil
+}
+
+func (s jobStore) Complete(ctx context.Context, id uuid.UUID) error {
+	return s.db.CompleteJob(ctx, id)
+}
+
+func (s jobStore) Retry(ctx context.Context, id uuid.UUID, runAt time.Time, lastError string) error {
+	return s.db.RetryJob(ctx, database.RetryJobParams{ID: id, RunAt: runAt, LastError: lastError})
+}
+
+func (s jobStore) Fail(ctx context.Context, id uuid.UUID, lastError string) error {
+	return s.db.FailJob(ctx, database.FailJobParams{ID: id, LastError: lastError})
+}
+
+// registerJobs sets up the handlers and recurring schedules for background
+// work.
+func (cfg *apiConfig) registerJobs(q *jobs.Queue) error {
+	jobs.Handle(q, purgeAccountsJob, cfg.purgeDeletedAccounts, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, expireMembershipsJob, cfg.expireLapsedMemberships, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, purgeLoginThrottlesJob, cfg.purgeLoginThrottles, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, purgeWebhookDeliveriesJob, cfg.purgeWebhookDeliveries, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, purgeTokensJob, cfg.purgeRefreshTokens, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, purgeJobsJob, cfg.purgeFinishedJobs, jobs.HandlerOptions{MaxAttempts: 1})
+	jobs.Handle(q, buildDataExportJob, cfg.buildDataExport, jobs.HandlerOptions{MaxAttempts: 3, Timeout: dataExportTimeout})
+
+	// Each run catches up on everything due, so a failed run needn't be
+	// retried; the next one will do.
+	for _, recurring := range []struct {
+		kind     jobs.Kind[struct{}]
+		schedule string
+	}{
+		{purgeAccountsJob, "0 * * * *"},
+		{expireMembershipsJob, "*/15 * * * *"},
+		{purgeLoginThrottlesJob, "5 * * * *"},
+		{purgeWebhookDeliveriesJob, "15 * * * *"},
+		{purgeTokensJob, "30 * * * *"},
+		{purgeJobsJob, "45 * * * *"},
+	} {
+		if err := jobs.ScheduleRecurring(q, recurring.kind, recurring.schedule, struct{}{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeFinishedJobs deletes jobs that succeeded or failed more than
+// jobRetention ago, a batch at a time. It runs as a recurring job, so it
+// clears out its own earlier runs too.
+func (cfg *apiConfig) purgeFinishedJobs(ctx context.Context, _ struct{}) error {
+	cutoff := sql.NullTime{Time: time.Now().UTC().Add(-cfg.jobRetention), Valid: true}
+
+	var purged int64
+	defer func() {
+		if purged > 0 {
+			log.Printf("Purged %d finished jobs", purged)
+		}
+	}()
+	for {
+		n, err := cfg.DB.PurgeFinishedJobs(ctx, database.PurgeFinishedJobsParams{
+			Cutoff:    cutoff,
+			BatchSize: jobPurgeBatch,
+		})
+		if err != nil {
+			return err
+		}
+		purged += n
+		if n < jobPurgeBatch {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+type JobResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	LastError   string          `json:"last_error"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+func jobResponse(job database.Job) JobResponse {
+	resp := JobResponse{
+		ID:        job.ID,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+		Kind:      job.Kind,
+		Payload:   json.RawMessage(job.Payload),
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		RunAt:     job.RunAt,
+		LastError: job.LastError,
+	}
+	if job.LockedUntil.Valid {
+		resp.LockedUntil = &job.LockedUntil.Time
+	}
+	if job.FinishedAt.Valid {
+		resp.FinishedAt = &job.FinishedAt.Time
+	}
+	return resp
+}
+
+// handlerListJobs lists background jobs, newest first, filtered on status
+// and kind; status=failed shows the jobs that gave up.
+func (cfg *apiConfig) handlerListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := database.ListJobsParams{Limit: defaultJobLimit}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxJobLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxJobLimit)+".", err)
+			return
+		}
+		params.Limit = int32(n)
+	}
+	for name, dst := range map[string]*sql.NullString{"status": &params.Status, "kind": &params.Kind} {
+		if v := query.Get(name); v != "" {
+			*dst = sql.NullString{String: v, Valid: true}
+		}
+	}
+
+	rows, err := cfg.DB.ListJobs(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving jobs.", err)
+		return
+	}
+
+	resp := make([]JobResponse, 0, len(rows))
+	for _, job := range rows {
+		resp = append(resp, jobResponse(job))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerGetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID.", err)
+		return
+	}
+
+	job, err := cfg.DB.GetJob(r.Context(), jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Job does not exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving job.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, jobResponse(job))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"log"
+	"net/http"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/config"
+)
+
+// configureJWTKeys loads access token keys from jwt.keys_dir. Without it, an
+// ephemeral Ed25519 key is generated, which is fine for development but
+// invalidates every access token on restart.
+func configureJWTKeys(conf config.JWT) (*auth.KeySet, error) {
+	issuer, audience := conf.Issuer, conf.Audience
+	if conf.KeysDir != "" {
+		return auth.LoadKeySet(conf.KeysDir, conf.SigningKeyID, issuer, audience)
+	}
+
+	log.Println("jwt.keys_dir not set; signing access tokens with an ephemeral key")
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keys := auth.NewKeySet(issuer, audience)
+	if err := keys.AddKey("ephemeral", priv); err != nil {
+		return nil, err
+	}
+	if err := keys.SetSigningKey("ephemeral"); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtKeys.JWKS())
+}
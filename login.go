@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/bdjekel/chirpy/internal/auth"
@@ -18,16 +20,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Email 		string `json:"email"`
 	}
 
-	type LoginResponse struct {
-		ID 				uuid.UUID	`json:"id"` 
-		CreatedAt 		time.Time	`json:"created_at"`
-		UpdatedAt 		time.Time	`json:"updated_at"`
-		Email     		string 		`json:"email"`
-		IsChirpyRed 	bool		`json:"is_chirpy_red"`
-		Token			string		`json:"token"`
-		RefreshToken 	string		`json:"refresh_token"`
-	}
-
 // Decode request
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -37,20 +29,98 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}	
 
+	ip := clientIP(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), params.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking login attempts.", err)
+		return
+	}
+	if wait > 0 {
+		cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, Detail: "throttled: " + cfg.auditEmail(params.Email)})
+		respondWithLoginThrottled(w, wait)
+		return
+	}
+
+	// The same response, and about the same time, whether or not the email
+	// has an account.
 	user, err := cfg.DB.UserLogin(r.Context(), params.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.passwordParams.DummyCheck(params.Password)
+		cfg.recordLoginFailure(r.Context(), params.Email, ip)
+		cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, Detail: "unknown email: " + cfg.auditEmail(params.Email)})
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password.", err)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Email is not associated with an account.", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
 		return
 	}
 	if err := auth.CheckPasswordHash(user.HashedPassword, params.Password); err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect Password.", err)
+		cfg.recordLoginFailure(r.Context(), params.Email, ip)
+		cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, UserID: user.ID, Detail: "wrong password"})
+		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password.", err)
+		return
+	}
+	cfg.upgradePasswordHash(r.Context(), user, params.Password)
+
+	// Accounts with 2FA get a challenge instead of tokens.
+	mfaEnabled, err := cfg.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking two-factor status.", err)
+		return
+	}
+	if mfaEnabled {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.clearLoginFailures(r.Context(), user.Email)
+	cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditSuccess, ActorID: user.ID, UserID: user.ID, Detail: "password"})
+	cfg.respondWithLoginTokens(w, r, user)
+}
+
+// upgradePasswordHash rehashes a password that has just been checked if its
+// hash predates the current Argon2id parameters, or is still bcrypt. Failing
+// to is logged; the old hash keeps working.
+func (cfg *apiConfig) upgradePasswordHash(ctx context.Context, user database.User, password string) {
+	if !cfg.passwordParams.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	hashedPassword, err := cfg.passwordParams.Hash(password)
+	if err == nil {
+		err = cfg.DB.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+			ID:             user.ID,
+			HashedPassword: hashedPassword,
+		})
+	}
+	if err != nil {
+		log.Printf("Error rehashing password of user %s: %s", user.ID, err)
+	}
+}
+
+// respondWithLoginTokens issues a fresh access and refresh token pair for a
+// fully authenticated user.
+func (cfg *apiConfig) respondWithLoginTokens(w http.ResponseWriter, r *http.Request, user database.User) {
+	type LoginResponse struct {
+		ID 				uuid.UUID	`json:"id"` 
+		CreatedAt 		time.Time	`json:"created_at"`
+		UpdatedAt 		time.Time	`json:"updated_at"`
+		Email     		string 		`json:"email"`
+		IsChirpyRed 	bool		`json:"is_chirpy_red"`
+		Role			string		`json:"role"`
+		Token			string		`json:"token"`
+		RefreshToken 	string		`json:"refresh_token"`
 	}
 
+	// Each login starts a new session, i.e. a new refresh token family;
+	// rotations stay within it.
+	sessionID := uuid.New()
 	expiresIn := 3600 * time.Second
 	
-	access_token, err := auth.MakeJWT(user.ID, os.Getenv("SECRET"), expiresIn)
+	access_token, err := cfg.jwtKeys.MakeSessionJWT(user.ID, sessionID, user.Role, expiresIn)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Error creating access_token.", err)
+		return
 	}
 
 
@@ -60,9 +130,12 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refresh_token, err := cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token: refresh_token_string,
+	_, err = cfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(refresh_token_string),
 		UserID: user.ID,
+		FamilyID: sessionID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating refresh_token", err)
@@ -75,46 +148,121 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: 	user.UpdatedAt,
 		Email:     	user.Email,
 		IsChirpyRed: user.IsChirpyRed,
+		Role:		user.Role,
 		Token:		access_token,
-		RefreshToken: refresh_token.Token,
+		RefreshToken: refresh_token_string,
 	})
 }
 
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 
 	type RefreshResponse struct {
-		Token string `json:"token"`
+		Token 			string `json:"token"`
+		RefreshToken 	string `json:"refresh_token"`
 	}
 
 	// Validate Refresh Token
 	refresh_token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error retreiving refresh_token.", err)
+		respondWithError(w, http.StatusUnauthorized, "Error retreiving refresh_token.", err)
 		return
 	}
 	
-	refresh_token_data, err := cfg.DB.GetRefreshToken(r.Context(), refresh_token)
+	refresh_token_hash := auth.HashToken(refresh_token)
+	refresh_token_data, err := cfg.DB.GetRefreshToken(r.Context(), refresh_token_hash)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Refresh token does not exist.", err)
 		return
 	}
 
+	// A rotated token coming back means it leaked: kill the whole family.
+	if refresh_token_data.RotatedAt.Valid {
+		cfg.revokeRefreshTokenFamily(r.Context(), refresh_token_data.FamilyID)
+		cfg.audit(r, auditEvent{Action: auditTokenRefresh, Outcome: auditFailure, UserID: refresh_token_data.UserID, Detail: "reused refresh token; session " + refresh_token_data.FamilyID.String() + " revoked"})
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has already been used.", nil)
+		return
+	}
+
 	if refresh_token_data.RevokedAt.Valid || refresh_token_data.ExpiresAt.Before(time.Now())  {
-		respondWithError(w, http.StatusUnauthorized, "Refresh token is expired or has been revoked.", err)
+		respondWithError(w, http.StatusUnauthorized, "Refresh token is expired or has been revoked.", nil)
+		return
+	}
+
+	// Rotate: retire the presented token and issue its successor.
+	new_refresh_token, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh_token_string", err)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rotating refresh_token.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	rotated, err := qtx.RotateRefreshToken(r.Context(), refresh_token_hash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rotating refresh_token.", err)
+		return
+	}
+	if rotated == 0 {
+		// Lost a race with another use of the same token.
+		tx.Rollback()
+		cfg.revokeRefreshTokenFamily(r.Context(), refresh_token_data.FamilyID)
+		cfg.audit(r, auditEvent{Action: auditTokenRefresh, Outcome: auditFailure, UserID: refresh_token_data.UserID, Detail: "reused refresh token; session " + refresh_token_data.FamilyID.String() + " revoked"})
+		respondWithError(w, http.StatusUnauthorized, "Refresh token has already been used.", nil)
+		return
+	}
+
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(new_refresh_token),
+		UserID: refresh_token_data.UserID,
+		FamilyID: refresh_token_data.FamilyID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh_token", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rotating refresh_token.", err)
+		return
+	}
+
+	// Look the user up again so role changes are picked up on refresh.
+	user, err := cfg.DB.GetUserByID(r.Context(), refresh_token_data.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
+		return
 	}
 
 	expiresIn := 3600 * time.Second
 	
-	access_token, err := auth.MakeJWT(refresh_token_data.UserID, os.Getenv("SECRET"), expiresIn)
+	access_token, err := cfg.jwtKeys.MakeSessionJWT(user.ID, refresh_token_data.FamilyID, user.Role, expiresIn)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Error creating access_token.", err)
 		return
 	}
 	
-	respondWithJSON(w, http.StatusOK, RefreshResponse{ Token: access_token })
+	cfg.audit(r, auditEvent{Action: auditTokenRefresh, Outcome: auditSuccess, ActorID: user.ID, UserID: user.ID, Detail: "session " + refresh_token_data.FamilyID.String()})
+	respondWithJSON(w, http.StatusOK, RefreshResponse{
+		Token: access_token,
+		RefreshToken: new_refresh_token,
+	})
 
 }
 
+func (cfg *apiConfig) revokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) {
+	if err := cfg.DB.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", familyID, err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 
 	// Validate Refresh Token
@@ -124,29 +272,20 @@ func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	refresh_token_data, err := cfg.DB.GetRefreshToken(r.Context(), refresh_token)
+	refresh_token_data, err := cfg.DB.GetRefreshToken(r.Context(), auth.HashToken(refresh_token))
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Refresh token does not exist.", err)
 		return
 	}
 
-	refresh_token_data.UpdatedAt = time.Now()
-
-	refresh_token_data.RevokedAt = 	sql.NullTime{
-		Time: time.Now(),
-		Valid: true,
-	}
-
-	err = cfg.DB.UpdateRefreshToken(r.Context(), database.UpdateRefreshTokenParams{
-		Token: refresh_token_data.Token,
-		UpdatedAt: time.Now(),
-		RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
-	})
+	// Revoking ends the session, which is the whole token family.
+	err = cfg.DB.RevokeRefreshTokenFamily(r.Context(), refresh_token_data.FamilyID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Refresh token not updated.", err)
 		return
 	}
+	cfg.audit(r, auditEvent{Action: auditTokenRevoke, Outcome: auditSuccess, ActorID: refresh_token_data.UserID, UserID: refresh_token_data.UserID, Detail: "session " + refresh_token_data.FamilyID.String()})
 
 	respondWithJSON(w, http.StatusNoContent, nil)
 
-}
\ No newline at end of file
+}
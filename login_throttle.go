@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Failed logins are counted per email address, whether or not it has an
+// account (so lockouts don't reveal which do), and per client IP. Counts
+// reset once no failure has happened for loginFailureWindow.
+const (
+	loginFailureWindow = 15 * time.Minute
+	loginLockout       = 15 * time.Minute
+	maxLoginDelay      = 30 * time.Second
+)
+
+type loginThrottle struct {
+	key string
+	// freeAttempts failures are allowed before each further attempt has
+	// to wait, twice as long each time up to maxLoginDelay.
+	freeAttempts int32
+	// lockAfter failures lock the key out for loginLockout.
+	lockAfter int32
+}
+
+// loginThrottles are the throttles a login for email from ip counts
+// against. Many users can share an IP behind NAT, so it gets no delays and a
+// much higher lockout threshold.
+func loginThrottles(email, ip string) []loginThrottle {
+	return []loginThrottle{
+		{key: accountThrottleKey(email), freeAttempts: 3, lockAfter: 10},
+		{key: "ip:" + ip, freeAttempts: math.MaxInt32, lockAfter: 100},
+	}
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginDelay(failures, freeAttempts int32) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	shift := failures - freeAttempts
+	if shift > 5 {
+		return maxLoginDelay
+	}
+	return min(time.Second<<shift, maxLoginDelay)
+}
+
+// loginRetryAfter reports how long a login for email from ip must wait,
+// which is zero if it may go ahead now.
+func (cfg *apiConfig) loginRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	now := time.Now().UTC()
+	var wait time.Duration
+	for _, t := range loginThrottles(email, ip) {
+		state, err := cfg.DB.GetLoginThrottle(ctx, t.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if state.LockedUntil.Valid && state.LockedUntil.Time.After(now) {
+			wait = max(wait, state.LockedUntil.Time.Sub(now))
+			continue
+		}
+		if state.LastFailedAt.Before(now.Add(-loginFailureWindow)) {
+			continue
+		}
+		next := state.LastFailedAt.Add(loginDelay(state.Failures, t.freeAttempts))
+		wait = max(wait, next.Sub(now))
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed password or second factor, locking out
+// whichever throttles have now had too many.
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, email, ip string) {
+	now := time.Now().UTC()
+	for _, t := range loginThrottles(email, ip) {
+		state, err := cfg.DB.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+			Key:          t.key,
+			LastFailedAt: now,
+			WindowStart:  now.Add(-loginFailureWindow),
+		})
+		if err != nil {
+			log.Printf("Error recording login failure for %s: %s", t.key, err)
+			continue
+		}
+		if state.Failures >= t.lockAfter {
+			log.Printf("Locking out %s after %d failed logins", t.key, state.Failures)
+			err := cfg.DB.LockLoginThrottle(ctx, database.LockLoginThrottleParams{
+				Key:         t.key,
+				LockedUntil: sql.NullTime{Time: now.Add(loginLockout), Valid: true},
+			})
+			if err != nil {
+				log.Printf("Error locking out %s: %s", t.key, err)
+			}
+		}
+	}
+}
+
+// clearLoginFailures forgets an account's failures once it has fully signed
+// in. The IP's count stays, or an attacker could reset it by signing in to an
+// account of their own between guesses.
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, email string) {
+	if err := cfg.DB.ClearLoginThrottle(ctx, accountThrottleKey(email)); err != nil {
+		log.Printf("Error clearing login failures for %s: %s", email, err)
+	}
+}
+
+// purgeLoginThrottles deletes throttles that have gone stale, which would
+// otherwise pile up for every email address anyone has guessed at. It runs
+// as a recurring job.
+func (cfg *apiConfig) purgeLoginThrottles(ctx context.Context, _ struct{}) error {
+	purged, err := cfg.DB.PurgeLoginThrottles(ctx, time.Now().UTC().Add(-loginFailureWindow))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d stale login throttles", purged)
+	}
+	return nil
+}
+
+func respondWithLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second)/time.Second)+1))
+	respondWithError(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.", nil)
+}
+
+// handlerUnlockAccount lifts a lockout on a user's account before it
+// expires.
+func (cfg *apiConfig) handlerUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID.", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
+		return
+	}
+
+	if err := cfg.DB.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email)); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error unlocking account.", err)
+		return
+	}
+	caller, _ := principalFromContext(r.Context())
+	cfg.audit(r, auditEvent{Action: auditAccountUnlock, Outcome: auditSuccess, ActorID: caller.UserID, UserID: userID})
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
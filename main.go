@@ -1,90 +1,272 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/config"
 	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/entitlements"
+	"github.com/bdjekel/chirpy/internal/jobs"
+	"github.com/bdjekel/chirpy/internal/mailer"
+	"github.com/bdjekel/chirpy/internal/oauth"
+	"github.com/bdjekel/chirpy/internal/oidc"
+	"github.com/bdjekel/chirpy/internal/outbox"
+	"github.com/bdjekel/chirpy/internal/payments"
+	"github.com/bdjekel/chirpy/internal/webhook"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	refreshTokensPurged atomic.Int64
 	DB database.Queries
+	dbConn *sql.DB
 	platform string
 	secret string
-	polkaKey string
+	paymentProviders map[string]payments.Provider
+	jwtKeys *auth.KeySet
+	mailer mailer.Mailer
+	mailFrom string
+	emailVerificationRequired bool
+	deletionGracePeriod time.Duration
+	refreshTokenRetention time.Duration
+	webhookRetention time.Duration
+	jobRetention time.Duration
+	exportDir string
+	oauth *oauth.Server
+	oidcProviders map[string]*oidc.Provider
+	passwordParams auth.Argon2Params
+	passwordPolicy passwordPolicy
+	entitlements *entitlements.Service
+	webhookClient *http.Client
+	outbox outbox.Publisher
 }
 
 func main() {
-	const filepathRoot = "."
-	const port = "8080"
-
-	// Retrieve env variables
+	// Settings come from an optional TOML file, the environment (including
+	// .env) and flags; see internal/config.
 	godotenv.Load()
-	
-	dbURL := os.Getenv("DB_URL")
-	if dbURL == "" {
-		log.Fatal("DB_URL must be set")
-	}
 
-	platform := os.Getenv("PLATFORM")
-	if platform == "" {
-		log.Fatal("PLATFORM must be set")
+	conf, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(os.Stderr, "%s\n\nflags:\n%s", usage, config.Usage())
+		return
+	}
+	if err != nil {
+		log.Fatalf("Configuration is invalid: %s", err)
 	}
 
 	// Connect to database
-	dbConnection, err := sql.Open("postgres", dbURL)
+	dbConnection, err := sql.Open("postgres", conf.DBURL.Reveal())
 	if err != nil {
 		log.Fatalf("Connection to database could not be established: %s", err)
 	}
 
 	dbQueries := database.New(dbConnection)
 
+	// One-off commands such as `chirpy set-role EMAIL admin`
+	if len(args) > 0 {
+		if err := runCommand(context.Background(), conf, dbQueries, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Load access token signing keys
+	jwtKeys, err := configureJWTKeys(conf.JWT)
+	if err != nil {
+		log.Fatalf("JWT keys could not be loaded: %s", err)
+	}
+
+	passwordPolicy, err := configurePasswordPolicy(conf.Passwords)
+	if err != nil {
+		log.Fatalf("Password policy could not be loaded: %s", err)
+	}
+
+	paymentProviders, err := configurePaymentProviders(conf.Payments)
+	if err != nil {
+		log.Fatalf("Payment providers could not be configured: %s", err)
+	}
+
+	tiers, err := entitlements.New(conf.Entitlements)
+	if err != nil {
+		log.Fatalf("Entitlements could not be configured: %s", err)
+	}
+
+	// Configure outgoing mail
+	mail, err := configureMailer(conf.Mail)
+	if err != nil {
+		log.Fatalf("Mailer could not be configured: %s", err)
+	}
+
+	// Behind a load balancer, name it here so clients are told apart.
+	trustedProxies, err := parseTrustedProxies(conf.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Trusted proxies could not be configured: %s", err)
+	}
 
 	apiCfg := apiConfig{
 		fileserverHits: atomic.Int32{},
 		DB: *dbQueries,
-		platform: platform,
-		secret: os.Getenv("SECRET"),
-		polkaKey: os.Getenv("POLKA_KEY"),
+		dbConn: dbConnection,
+		platform: conf.Platform,
+		secret: conf.Secret.Reveal(),
+		paymentProviders: paymentProviders,
+		jwtKeys: jwtKeys,
+		mailer: mail,
+		mailFrom: conf.Mail.From,
+		emailVerificationRequired: conf.RequireEmailVerification,
+		deletionGracePeriod: conf.AccountDeletionGrace,
+		refreshTokenRetention: conf.RefreshTokenRetention,
+		webhookRetention: conf.WebhookRetention,
+		jobRetention: conf.JobRetention,
+		exportDir: conf.ExportDir,
+		oidcProviders: configureOIDCProviders(conf.OIDC),
+		passwordParams: configurePasswordHashing(conf.Passwords),
+		passwordPolicy: passwordPolicy,
+		entitlements: tiers,
+		webhookClient: webhook.NewClient(webhookDeliveryTimeout, conf.Platform == "dev"),
+	}
+	bus := outbox.NewBus()
+	apiCfg.subscribeOutbox(bus)
+	apiCfg.outbox = bus
+	apiCfg.oauth = oauth.NewServer(oauthStore{db: &apiCfg.DB}, oauthUsers{cfg: &apiCfg}, jwtKeys, oauthScopes)
+
+	// Stop on SIGINT or SIGTERM, letting in-flight work finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Background work
+	queue := jobs.New(jobStore{db: dbQueries}, jobs.Options{Lease: jobLease})
+	if err := apiCfg.registerJobs(queue); err != nil {
+		log.Fatalf("Jobs could not be registered: %s", err)
 	}
+	queue.Start()
+	go apiCfg.runWebhookDispatcher(ctx, 5*time.Second)
+	go apiCfg.runOutboxRelay(ctx, time.Second)
 
 	mux := http.NewServeMux()
 
 	// Middleware
-	fileServerWithHitTracker := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot))))
+	fileServerWithHitTracker := apiCfg.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(conf.Server.FileRoot))))
 	mux.Handle("/app/", fileServerWithHitTracker)
 
+	// Public signing keys for other services verifying Chirpy tokens
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+
+	// OAuth 2.0 authorization server for third-party apps
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.oauth.HandleAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.oauth.HandleAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", apiCfg.oauth.HandleToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.oauth.HandleIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.oauth.HandleRevoke)
+
 	// api endpoints
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/auth/oidc/{provider}/callback", apiCfg.handlerOIDCCallback)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
 	mux.HandleFunc("GET /api/chirps/{id}", apiCfg.handlerGetChirpByID)
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
 	mux.HandleFunc("POST /api/chirps", apiCfg.handlerChirps)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}", apiCfg.handlerUpdateChirp)
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	mux.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.handlerConfirmTOTP)
+	mux.HandleFunc("POST /api/mfa/totp/disable", apiCfg.handlerDisableTOTP)
+	mux.HandleFunc("POST /api/mfa/totp/enroll", apiCfg.handlerEnrollTOTP)
+	mux.HandleFunc("GET /api/oauth/clients", apiCfg.handlerListOAuthClients)
+	mux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerCreateOAuthClient)
+	mux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.handlerDeleteOAuthClient)
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	mux.HandleFunc("GET /api/sessions", apiCfg.handlerListSessions)
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", apiCfg.handlerRevokeSession)
+	mux.HandleFunc("POST /api/sessions/revoke-others", apiCfg.handlerRevokeOtherSessions)
+	mux.HandleFunc("GET /api/tokens", apiCfg.handlerListPersonalAccessTokens)
+	mux.HandleFunc("POST /api/tokens", apiCfg.handlerCreatePersonalAccessToken)
+	mux.HandleFunc("DELETE /api/tokens/{tokenID}", apiCfg.handlerRevokePersonalAccessToken)
+	mux.HandleFunc("GET /api/webhooks", apiCfg.handlerListWebhookEndpoints)
+	mux.HandleFunc("POST /api/webhooks", apiCfg.handlerCreateWebhookEndpoint)
+	mux.HandleFunc("PUT /api/webhooks/{endpointID}", apiCfg.handlerUpdateWebhookEndpoint)
+	mux.HandleFunc("DELETE /api/webhooks/{endpointID}", apiCfg.handlerDeleteWebhookEndpoint)
+	mux.HandleFunc("GET /api/webhooks/{endpointID}/deliveries", apiCfg.handlerListWebhookEndpointDeliveries)
+	mux.HandleFunc("POST /api/webhooks/{endpointID}/enable", apiCfg.handlerEnableWebhookEndpoint)
+	mux.HandleFunc("POST /api/webhooks/{endpointID}/test", apiCfg.handlerTestWebhookEndpoint)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
 	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateCredentials)
+	mux.HandleFunc("DELETE /api/users/me", apiCfg.handlerDeleteAccount)
+	mux.HandleFunc("GET /api/users/me/entitlements", apiCfg.handlerGetEntitlements)
+	mux.HandleFunc("POST /api/users/me/deletion/cancel", apiCfg.handlerCancelAccountDeletion)
+	mux.HandleFunc("POST /api/users/me/export", apiCfg.handlerRequestDataExport)
+	mux.HandleFunc("GET /api/users/me/security-events", apiCfg.handlerListSecurityEvents)
+	mux.HandleFunc("GET /api/users/me/exports/{exportID}", apiCfg.handlerGetDataExport)
+	mux.HandleFunc("POST /api/users/verify", apiCfg.handlerVerifyEmail)
+	mux.HandleFunc("POST /api/users/verify/resend", apiCfg.handlerResendVerification)
 	
 	// api webhook endpoints
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerMembershipUpgrade)
+	mux.HandleFunc("POST /api/payments/{provider}/webhooks", apiCfg.handlerPaymentWebhook)
+	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
 
 	// Admin endpoints
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	mux.Handle("GET /admin/audit", apiCfg.middlewareRequirePermission(permViewAuditLog, http.HandlerFunc(apiCfg.handlerListAuditEvents)))
+	mux.Handle("GET /admin/jobs", apiCfg.middlewareRequirePermission(permViewJobs, http.HandlerFunc(apiCfg.handlerListJobs)))
+	mux.Handle("GET /admin/jobs/{jobID}", apiCfg.middlewareRequirePermission(permViewJobs, http.HandlerFunc(apiCfg.handlerGetJob)))
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequirePermission(permViewMetrics, http.HandlerFunc(apiCfg.handlerMetrics)))
+	mux.Handle("POST /admin/reset", apiCfg.middlewareRequirePermission(permResetDatabase, http.HandlerFunc(apiCfg.handlerReset)))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequirePermission(permManageRoles, http.HandlerFunc(apiCfg.handlerSetUserRole)))
+	mux.Handle("GET /admin/users/{userID}/membership", apiCfg.middlewareRequirePermission(permViewMembership, http.HandlerFunc(apiCfg.handlerGetMembership)))
+	mux.Handle("POST /admin/users/{userID}/impersonate", apiCfg.middlewareRequirePermission(permImpersonate, http.HandlerFunc(apiCfg.handlerImpersonateUser)))
+	mux.Handle("POST /admin/users/{userID}/unlock", apiCfg.middlewareRequirePermission(permUnlockAccounts, http.HandlerFunc(apiCfg.handlerUnlockAccount)))
+	mux.Handle("GET /admin/webhooks", apiCfg.middlewareRequirePermission(permManageWebhooks, http.HandlerFunc(apiCfg.handlerListWebhookDeliveries)))
+	mux.Handle("POST /admin/webhooks/{id}/replay", apiCfg.middlewareRequirePermission(permManageWebhooks, http.HandlerFunc(apiCfg.handlerReplayWebhookDelivery)))
 
 	// Start server
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:    ":" + strconv.Itoa(conf.Server.Port),
+		Handler: middlewareClientIP(trustedProxies, middlewareRequestID(apiCfg.middlewareImpersonation(mux))),
 	}
 
-	log.Printf("Serving on port: %s\n", port)
-	log.Fatal(server.ListenAndServe())
-}
\ No newline at end of file
+	go func() {
+		log.Printf("Serving on port: %d\n", conf.Server.Port)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %s", err)
+	}
+	if err := queue.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error draining jobs: %s", err)
+	}
+}
+
+// configureMailer picks the outgoing mail transport: "smtp" for a real
+// relay, or "file" to drop messages into mail.dir.
+func configureMailer(conf config.Mail) (mailer.Mailer, error) {
+	if conf.Mailer == "smtp" {
+		return mailer.NewSMTPMailer(conf.SMTPHost, strconv.Itoa(conf.SMTPPort), conf.SMTPUsername, conf.SMTPPassword.Reveal()), nil
+	}
+	return mailer.NewFileMailer(conf.Dir)
+}
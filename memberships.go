@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/payments"
+	"github.com/google/uuid"
+)
+
+const (
+	// membershipPeriod is how long a payment buys when the provider doesn't
+	// say.
+	membershipPeriod = 30 * 24 * time.Hour
+	// membershipGracePeriod keeps a member whose payment failed on Chirpy
+	// Red past the end of their period while the provider retries the
+	// charge.
+	membershipGracePeriod = 7 * 24 * time.Hour
+)
+
+// Membership statuses, as stored in memberships.status. A member is on
+// Chirpy Red in every status but expired: a canceled membership runs to the
+// end of its period and a past-due one to the end of its grace period.
+const (
+	membershipActive   = "active"
+	membershipPastDue  = "past_due"
+	membershipCanceled = "canceled"
+	membershipExpired  = "expired"
+)
+
+var errMembershipUserNotFound = errors.New("user does not exist")
+
+// membershipChange is one event from the payment provider about a user's
+// membership.
+type membershipChange struct {
+	UserID uuid.UUID
+	// Event is one of the canonical events of package payments.
+	Event string
+	// Source and ProviderEventID say where the change came from, for the
+	// membership history.
+	Source          string
+	ProviderEventID string
+	// PeriodEnd is when a new or renewed period ends, if the provider says.
+	PeriodEnd time.Time
+}
+
+// applyMembershipChange moves a membership through its lifecycle inside the
+// caller's transaction. It reports whether the event meant anything: a
+// cancellation for someone who isn't a member, say, changes nothing.
+func applyMembershipChange(ctx context.Context, qtx *database.Queries, change membershipChange, now time.Time) (bool, error) {
+	if _, err := qtx.GetUserByID(ctx, change.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, errMembershipUserNotFound
+		}
+		return false, err
+	}
+
+	current, err := qtx.GetMembership(ctx, change.UserID)
+	hasMembership := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	var membership database.Membership
+	switch change.Event {
+	case payments.MembershipStarted, payments.MembershipRenewed:
+		// A renewal carries on from where the paid period ends; anything
+		// else starts now.
+		start := now
+		if change.Event == payments.MembershipRenewed && hasMembership &&
+			current.Status != membershipExpired && current.CurrentPeriodEnd.After(now) {
+			start = current.CurrentPeriodEnd
+			// A period the provider says ends no later than the one
+			// already paid for isn't a renewal: the first invoice of a
+			// subscription, say, or one delivered twice.
+			if !change.PeriodEnd.IsZero() && !change.PeriodEnd.After(start) {
+				return false, nil
+			}
+		}
+		end := change.PeriodEnd
+		if end.IsZero() {
+			end = start.Add(membershipPeriod)
+		}
+		membership, err = qtx.StartMembershipPeriod(ctx, database.StartMembershipPeriodParams{
+			UserID:             change.UserID,
+			CurrentPeriodStart: start,
+			CurrentPeriodEnd:   end,
+		})
+	case payments.MembershipCanceled:
+		membership, err = qtx.CancelMembership(ctx, change.UserID)
+	case payments.PaymentFailed:
+		graceFrom := now
+		if hasMembership && current.CurrentPeriodEnd.After(now) {
+			graceFrom = current.CurrentPeriodEnd
+		}
+		membership, err = qtx.MarkMembershipPastDue(ctx, database.MarkMembershipPastDueParams{
+			UserID:     change.UserID,
+			GraceUntil: sql.NullTime{Time: graceFrom.Add(membershipGracePeriod), Valid: true},
+		})
+	case payments.MembershipEnded:
+		membership, err = qtx.EndMembership(ctx, change.UserID)
+	default:
+		return false, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := recordMembership(ctx, qtx, membership, change.Event, change.Source, change.ProviderEventID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordMembership brings users.is_chirpy_red in line with membership and
+// adds event to its history.
+func recordMembership(ctx context.Context, qtx *database.Queries, membership database.Membership, event, source, providerEventID string) error {
+	err := qtx.SetUserChirpyRed(ctx, database.SetUserChirpyRedParams{
+		ID:          membership.UserID,
+		IsChirpyRed: membership.Status != membershipExpired,
+	})
+	if err != nil {
+		return err
+	}
+	return qtx.CreateMembershipEvent(ctx, database.CreateMembershipEventParams{
+		UserID:          membership.UserID,
+		Event:           event,
+		Source:          source,
+		ProviderEventID: sql.NullString{String: providerEventID, Valid: providerEventID != ""},
+		Status:          membership.Status,
+		PeriodEnd:       sql.NullTime{Time: membership.CurrentPeriodEnd, Valid: true},
+	})
+}
+
+// expireMemberships ends every membership whose period, and grace period if
+// any, is over.
+func (cfg *apiConfig) expireMemberships(ctx context.Context, now time.Time) (int, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	expired, err := qtx.ExpireMemberships(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, membership := range expired {
+		if err := recordMembership(ctx, qtx, membership, "expired", "system", ""); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), tx.Commit()
+}
+
+// expireLapsedMemberships takes lapsed members off Chirpy Red. It runs as a
+// recurring job.
+func (cfg *apiConfig) expireLapsedMemberships(ctx context.Context, _ struct{}) error {
+	expired, err := cfg.expireMemberships(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		log.Printf("Expired %d memberships", expired)
+	}
+	return nil
+}
+
+type MembershipResponse struct {
+	Status             string     `json:"status"`
+	CurrentPeriodStart time.Time  `json:"current_period_start"`
+	CurrentPeriodEnd   time.Time  `json:"current_period_end"`
+	GraceUntil         *time.Time `json:"grace_until,omitempty"`
+	CanceledAt         *time.Time `json:"canceled_at,omitempty"`
+}
+
+type MembershipEventResponse struct {
+	CreatedAt       time.Time  `json:"created_at"`
+	Event           string     `json:"event"`
+	Source          string     `json:"source"`
+	ProviderEventID string     `json:"provider_event_id,omitempty"`
+	Status          string     `json:"status"`
+	PeriodEnd       *time.Time `json:"period_end,omitempty"`
+}
+
+// handlerGetMembership shows support a user's membership and how it got
+// there.
+func (cfg *apiConfig) handlerGetMembership(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		UserID      uuid.UUID                 `json:"user_id"`
+		IsChirpyRed bool                      `json:"is_chirpy_red"`
+		Membership  *MembershipResponse       `json:"membership"`
+		History     []MembershipEventResponse `json:"history"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID.", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
+		return
+	}
+	resp := Response{UserID: user.ID, IsChirpyRed: user.IsChirpyRed, History: []MembershipEventResponse{}}
+
+	membership, err := cfg.DB.GetMembership(r.Context(), userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving membership.", err)
+		return
+	}
+	if err == nil {
+		resp.Membership = &MembershipResponse{
+			Status:             membership.Status,
+			CurrentPeriodStart: membership.CurrentPeriodStart,
+			CurrentPeriodEnd:   membership.CurrentPeriodEnd,
+		}
+		if membership.GraceUntil.Valid {
+			resp.Membership.GraceUntil = &membership.GraceUntil.Time
+		}
+		if membership.CanceledAt.Valid {
+			resp.Membership.CanceledAt = &membership.CanceledAt.Time
+		}
+	}
+
+	events, err := cfg.DB.ListMembershipEvents(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving membership history.", err)
+		return
+	}
+	for _, event := range events {
+		e := MembershipEventResponse{
+			CreatedAt:       event.CreatedAt,
+			Event:           event.Event,
+			Source:          event.Source,
+			ProviderEventID: event.ProviderEventID.String,
+			Status:          event.Status,
+		}
+		if event.PeriodEnd.Valid {
+			e.PeriodEnd = &event.PeriodEnd.Time
+		}
+		resp.History = append(resp.History, e)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
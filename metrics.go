@@ -8,6 +8,7 @@ import (
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 	// api endpoints
 	hits := cfg.fileserverHits.Load()
+	purged := cfg.refreshTokensPurged.Load()
 	r.Header.Set("Content-Type", "text/html")
 	w.Write([]byte(fmt.Sprintf(`
 <html>
@@ -15,10 +16,11 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
 	<p>Chirpy has been visited %d times!</p>
+	<p>%d expired or revoked refresh tokens have been purged.</p>
 </body>
 
 </html>
-	`, hits)))
+	`, hits, purged)))
 }
 
 
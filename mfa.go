@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	mfaChallengeExpiry = 5 * time.Minute
+	totpIssuer         = "Chirpy"
+	recoveryCodeCount  = 10
+)
+
+// mfaEnabled reports whether the user has a confirmed TOTP enrollment.
+func (cfg *apiConfig) mfaEnabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	totp, err := cfg.DB.GetTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, user database.User) {
+	type MFAChallengeResponse struct {
+		Error       string `json:"error"`
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	mfaToken, err := auth.MakeMFAChallengeToken(user.ID, cfg.secret, mfaChallengeExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating mfa_token.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusUnauthorized, MFAChallengeResponse{
+		Error:       "Two-factor code required.",
+		MFARequired: true,
+		MFAToken:    mfaToken,
+	})
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code for the
+// user and consumes it, so neither can be used twice.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, userID uuid.UUID, code, recoveryCode string) (bool, error) {
+	totp, err := cfg.DB.GetTOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !totp.ConfirmedAt.Valid {
+		return false, nil
+	}
+
+	if code != "" {
+		secret, err := auth.DecryptSecret(totp.EncryptedSecret, cfg.secret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := auth.ValidateTOTP(secret, code, time.Now(), totp.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+		used, err := cfg.DB.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+
+	if recoveryCode != "" {
+		used, err := cfg.DB.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		return used == 1, err
+	}
+
+	return false, nil
+}
+
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Validate MFA Challenge Token
+	userID, err := auth.ValidateMFAChallengeToken(params.MFAToken, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired mfa_token.", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "User does not exist.", err)
+		return
+	}
+
+	// Wrong codes count against the account like wrong passwords, so a
+	// leaked password doesn't leave the six digits open to guessing.
+	ip := clientIP(r)
+	wait, err := cfg.loginRetryAfter(r.Context(), user.Email, ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking login attempts.", err)
+		return
+	}
+	if wait > 0 {
+		cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, UserID: user.ID, Detail: "throttled at second factor"})
+		respondWithLoginThrottled(w, wait)
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), userID, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error verifying two-factor code.", err)
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(r.Context(), user.Email, ip)
+		cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, UserID: user.ID, Detail: "wrong second factor"})
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code.", nil)
+		return
+	}
+
+	cfg.clearLoginFailures(r.Context(), user.Email)
+	cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditSuccess, ActorID: user.ID, UserID: user.ID, Detail: "password and second factor"})
+	cfg.respondWithLoginTokens(w, r, user)
+}
+
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	type EnrollmentResponse struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+		QRCodePNG       string `json:"qr_code_png"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+
+	// Generate and store a pending secret; any earlier pending one is replaced.
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating secret.", err)
+		return
+	}
+	encrypted, err := auth.EncryptSecret(secret, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating secret.", err)
+		return
+	}
+
+	_, err = cfg.DB.UpsertPendingTOTP(r.Context(), database.UpsertPendingTOTPParams{
+		UserID:          userID,
+		EncryptedSecret: encrypted,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting enrollment.", err)
+		return
+	}
+
+	uri := auth.TOTPProvisioningURI(secret, user.Email, totpIssuer)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error rendering QR code.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, EnrollmentResponse{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRCodePNG:       base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	type ConfirmationResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.DB.GetTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "No pending two-factor enrollment.", err)
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.", nil)
+		return
+	}
+
+	secret, err := auth.DecryptSecret(totp.EncryptedSecret, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error reading secret.", err)
+		return
+	}
+	step, ok := auth.ValidateTOTP(secret, params.Code, time.Now(), totp.LastUsedStep)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid two-factor code.", nil)
+		return
+	}
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error generating recovery codes.", err)
+		return
+	}
+
+	// Enable 2FA and store the recovery code hashes together.
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	err = qtx.ConfirmTOTP(r.Context(), database.ConfirmTOTPParams{
+		UserID:       userID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication.", err)
+		return
+	}
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication.", err)
+		return
+	}
+	for _, code := range recoveryCodes {
+		err := qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashRecoveryCode(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication.", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication.", err)
+		return
+	}
+
+	// The plaintext codes are shown exactly once.
+	respondWithJSON(w, http.StatusOK, ConfirmationResponse{RecoveryCodes: recoveryCodes})
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), userID, params.Code, params.RecoveryCode)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error verifying two-factor code.", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid two-factor code.", nil)
+		return
+	}
+
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	if err := qtx.DeleteTOTP(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication.", err)
+		return
+	}
+	if err := qtx.DeleteRecoveryCodes(r.Context(), userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication.", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+// oauthScopes is what third-party apps may ask for, and how the consent
+// screen describes it.
+var oauthScopes = map[string]string{
+	scopeChirpsWrite:  "Post and delete chirps as you",
+	scopeProfileRead:  "See your email address and membership",
+	scopeProfileWrite: "Update your profile, but not your email address or password",
+}
+
+// oauthStore backs the authorization server with Postgres.
+type oauthStore struct {
+	db *database.Queries
+}
+
+func oauthError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return oauth.ErrNotFound
+	}
+	return err
+}
+
+func (s oauthStore) GetClient(ctx context.Context, id uuid.UUID) (oauth.Client, error) {
+	c, err := s.db.GetOAuthClient(ctx, id)
+	if err != nil {
+		return oauth.Client{}, oauthError(err)
+	}
+	return oauth.Client{ID: c.ID, Name: c.Name, SecretHash: c.SecretHash.String, RedirectURIs: c.RedirectUris}, nil
+}
+
+func (s oauthStore) CreateGrant(ctx context.Context, g oauth.Grant) error {
+	return s.db.CreateOAuthGrant(ctx, database.CreateOAuthGrantParams{
+		ID:       g.ID,
+		Scopes:   g.Scopes,
+		ClientID: g.ClientID,
+		UserID:   g.UserID,
+	})
+}
+
+func (s oauthStore) GetGrant(ctx context.Context, id uuid.UUID) (oauth.Grant, error) {
+	g, err := s.db.GetOAuthGrant(ctx, id)
+	if err != nil {
+		return oauth.Grant{}, oauthError(err)
+	}
+	return oauth.Grant{ID: g.ID, ClientID: g.ClientID, UserID: g.UserID, Scopes: g.Scopes, Revoked: g.RevokedAt.Valid}, nil
+}
+
+func (s oauthStore) RevokeGrant(ctx context.Context, id uuid.UUID) error {
+	return s.db.RevokeOAuthGrant(ctx, id)
+}
+
+func (s oauthStore) CreateAuthorizationCode(ctx context.Context, c oauth.AuthorizationCode) error {
+	return s.db.CreateOAuthAuthorizationCode(ctx, database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      c.CodeHash,
+		ExpiresAt:     c.ExpiresAt,
+		RedirectUri:   c.RedirectURI,
+		CodeChallenge: c.CodeChallenge,
+		GrantID:       c.GrantID,
+	})
+}
+
+func (s oauthStore) GetAuthorizationCode(ctx context.Context, codeHash string) (oauth.AuthorizationCode, error) {
+	c, err := s.db.GetOAuthAuthorizationCode(ctx, codeHash)
+	if err != nil {
+		return oauth.AuthorizationCode{}, oauthError(err)
+	}
+	return oauth.AuthorizationCode{
+		CodeHash:      c.CodeHash,
+		GrantID:       c.GrantID,
+		RedirectURI:   c.RedirectUri,
+		CodeChallenge: c.CodeChallenge,
+		ExpiresAt:     c.ExpiresAt,
+		Used:          c.UsedAt.Valid,
+	}, nil
+}
+
+func (s oauthStore) UseAuthorizationCode(ctx context.Context, codeHash string) (bool, error) {
+	used, err := s.db.UseOAuthAuthorizationCode(ctx, codeHash)
+	return used == 1, err
+}
+
+func (s oauthStore) CreateRefreshToken(ctx context.Context, t oauth.RefreshToken) error {
+	return s.db.CreateOAuthRefreshToken(ctx, database.CreateOAuthRefreshTokenParams{
+		TokenHash: t.TokenHash,
+		ExpiresAt: t.ExpiresAt,
+		GrantID:   t.GrantID,
+	})
+}
+
+func (s oauthStore) GetRefreshToken(ctx context.Context, tokenHash string) (oauth.RefreshToken, error) {
+	t, err := s.db.GetOAuthRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return oauth.RefreshToken{}, oauthError(err)
+	}
+	return oauth.RefreshToken{TokenHash: t.TokenHash, GrantID: t.GrantID, ExpiresAt: t.ExpiresAt, Rotated: t.RotatedAt.Valid}, nil
+}
+
+func (s oauthStore) RotateRefreshToken(ctx context.Context, tokenHash string) (bool, error) {
+	rotated, err := s.db.RotateOAuthRefreshToken(ctx, tokenHash)
+	return rotated == 1, err
+}
+
+// oauthUsers signs users in on the consent screen with the same checks as
+// POST /api/login and /api/login/mfa.
+type oauthUsers struct {
+	cfg *apiConfig
+}
+
+var totpCodePattern = regexp.MustCompile(`^\d{6}$`)
+
+func (u oauthUsers) Authenticate(r *http.Request, email, password, otp string) (uuid.UUID, error) {
+	ctx := r.Context()
+	ip := clientIP(r)
+	wait, err := u.cfg.loginRetryAfter(ctx, email, ip)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if wait > 0 {
+		u.cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, Detail: "oauth consent throttled: " + u.cfg.auditEmail(email)})
+		return uuid.Nil, oauth.ErrTooManyAttempts
+	}
+
+	user, err := u.cfg.DB.UserLogin(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		u.cfg.passwordParams.DummyCheck(password)
+		u.cfg.recordLoginFailure(ctx, email, ip)
+		u.cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, Detail: "oauth consent unknown email: " + u.cfg.auditEmail(email)})
+		return uuid.Nil, oauth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err := auth.CheckPasswordHash(user.HashedPassword, password); err != nil {
+		u.cfg.recordLoginFailure(ctx, email, ip)
+		u.cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, UserID: user.ID, Detail: "oauth consent wrong password"})
+		return uuid.Nil, oauth.ErrInvalidCredentials
+	}
+	u.cfg.upgradePasswordHash(ctx, user, password)
+
+	mfaEnabled, err := u.cfg.mfaEnabled(ctx, user.ID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if mfaEnabled {
+		// The form asks for the second factor after the password, which
+		// isn't a failure.
+		if otp == "" {
+			return uuid.Nil, oauth.ErrSecondFactorRequired
+		}
+		code, recoveryCode := otp, ""
+		if !totpCodePattern.MatchString(otp) {
+			code, recoveryCode = "", otp
+		}
+		ok, err := u.cfg.verifySecondFactor(ctx, user.ID, code, recoveryCode)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !ok {
+			u.cfg.recordLoginFailure(ctx, email, ip)
+			u.cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditFailure, UserID: user.ID, Detail: "oauth consent wrong second factor"})
+			return uuid.Nil, oauth.ErrSecondFactorRequired
+		}
+	}
+
+	u.cfg.clearLoginFailures(ctx, email)
+	u.cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditSuccess, ActorID: user.ID, UserID: user.ID, Detail: "oauth consent"})
+	return user.ID, nil
+}
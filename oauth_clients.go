@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/oauth"
+	"github.com/google/uuid"
+)
+
+const maxOAuthRedirectURIs = 10
+
+type OAuthClient struct {
+	ClientID     uuid.UUID `json:"client_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	// ClientSecret is only ever returned once, when the client is registered.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func oauthClient(c database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ClientID:     c.ID,
+		CreatedAt:    c.CreatedAt,
+		Name:         c.Name,
+		RedirectURIs: c.RedirectUris,
+		Confidential: c.SecretHash.Valid,
+	}
+}
+
+// handlerCreateOAuthClient registers a third-party app. Confidential clients
+// (ones with a server to keep a secret on) get a client_secret; public ones
+// rely on PKCE alone.
+func (cfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters.", nil)
+		return
+	}
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxOAuthRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, "Between 1 and 10 redirect_uris are required.", nil)
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if err := oauth.ValidateRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid redirect URI "+uri+": "+err.Error(), err)
+			return
+		}
+	}
+
+	var secret string
+	var secretHash sql.NullString
+	if params.Confidential {
+		secret, err = oauth.NewSecret()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating client secret.", err)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.DB.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: params.RedirectURIs,
+		OwnerID:      caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error registering client.", err)
+		return
+	}
+
+	resp := oauthClient(client)
+	resp.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	clients, err := cfg.DB.ListOAuthClients(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving clients.", err)
+		return
+	}
+
+	resp := make([]OAuthClient, 0, len(clients))
+	for _, client := range clients {
+		resp = append(resp, oauthClient(client))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerDeleteOAuthClient removes a client, and with it every grant and
+// token it holds.
+func (cfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID.", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting client.", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Client does not exist.", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
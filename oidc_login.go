@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/config"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcStateCookie = "chirpy_oidc"
+	oidcLoginExpiry = 10 * time.Minute
+)
+
+var errIdentityEmailTaken = errors.New("an account with this email already exists")
+
+// configureOIDCProviders sets up the sign-in providers from the
+// configuration; see config.OIDCProvider.
+func configureOIDCProviders(conf map[string]config.OIDCProvider) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for name, p := range conf {
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret.Reveal(),
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+		}, nil)
+	}
+	return providers
+}
+
+// revokeUnverifiedCredentials locks out whoever set up an account before its
+// email was verified: the password is replaced with a random one, and every
+// session, access token, OAuth grant and second factor is revoked.
+func (cfg *apiConfig) revokeUnverifiedCredentials(ctx context.Context, qtx *database.Queries, userID uuid.UUID) error {
+	password, err := oidc.RandomValue()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := cfg.passwordParams.Hash(password)
+	if err != nil {
+		return err
+	}
+	err = qtx.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{ID: userID, HashedPassword: hashedPassword})
+	if err != nil {
+		return err
+	}
+
+	for _, revoke := range []func(context.Context, uuid.UUID) error{
+		qtx.RevokeAllRefreshTokensForUser,
+		qtx.RevokeAllPersonalAccessTokensForUser,
+		qtx.RevokeAllOAuthGrantsForUser,
+		qtx.InvalidatePasswordResets,
+		qtx.DeleteTOTP,
+		qtx.DeleteRecoveryCodes,
+	} {
+		if err := revoke(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oidcLoginState is what the login endpoint needs to remember until the
+// provider redirects back. It travels in an encrypted cookie, which also ties
+// the callback to the browser that started the login.
+type oidcLoginState struct {
+	Provider     string    `json:"provider"`
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) oidcProvider(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider.", nil)
+		return nil, false
+	}
+	return provider, true
+}
+
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	login := oidcLoginState{Provider: provider.Name(), ExpiresAt: time.Now().UTC().Add(oidcLoginExpiry)}
+	var err error
+	for _, v := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		if *v, err = oidc.RandomValue(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error starting sign-in.", err)
+			return
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider.", err)
+		return
+	}
+
+	data, err := json.Marshal(login)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting sign-in.", err)
+		return
+	}
+	sealed, err := auth.EncryptSecret(string(data), cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting sign-in.", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    sealed,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   int(oidcLoginExpiry.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.platform != "dev",
+		// Lax, so the cookie survives the top-level redirect back from the
+		// provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Sign-in session not found. Please start again.", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc/", MaxAge: -1, HttpOnly: true, Secure: cfg.platform != "dev"})
+
+	var login oidcLoginState
+	data, err := auth.DecryptSecret(cookie.Value, cfg.secret)
+	if err == nil {
+		err = json.Unmarshal([]byte(data), &login)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Sign-in session is invalid. Please start again.", err)
+		return
+	}
+	query := r.URL.Query()
+	if login.Provider != provider.Name() || time.Now().UTC().After(login.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Sign-in session is invalid or has expired. Please start again.", nil)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "The identity provider refused the sign-in: "+errCode, nil)
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete sign-in with the identity provider.", err)
+		return
+	}
+	identity, err := provider.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "The identity provider's ID token is invalid.", err)
+		return
+	}
+
+	user, err := cfg.userForIdentity(r.Context(), provider.Name(), identity)
+	if errors.Is(err, errIdentityEmailTaken) {
+		respondWithError(w, http.StatusConflict, "An account with this email already exists. Sign in with your password instead.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error signing in.", err)
+		return
+	}
+
+	// Chirpy's own second factor still applies on top of the provider's.
+	mfaEnabled, err := cfg.mfaEnabled(r.Context(), user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error checking two-factor status.", err)
+		return
+	}
+	if mfaEnabled {
+		cfg.respondWithMFAChallenge(w, user)
+		return
+	}
+
+	cfg.audit(r, auditEvent{Action: auditLogin, Outcome: auditSuccess, ActorID: user.ID, UserID: user.ID, Detail: "oidc: " + provider.Name()})
+	cfg.respondWithLoginTokens(w, r, user)
+}
+
+// userForIdentity finds the user linked to an external identity. On first
+// login the identity is linked to the account with the same email, if the
+// provider vouches for that email, or else to a new account.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, provider string, identity oidc.Identity) (database.User, error) {
+	linked, err := cfg.DB.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+	})
+	if err == nil {
+		if identity.Email != "" && identity.Email != linked.Email {
+			err := cfg.DB.UpdateUserIdentityEmail(ctx, database.UpdateUserIdentityEmailParams{ID: linked.ID, Email: identity.Email})
+			if err != nil {
+				log.Printf("Error updating email of identity %s: %s", linked.ID, err)
+			}
+		}
+		return cfg.DB.GetUserByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if err := validateEmail(identity.Email); err != nil {
+		return database.User{}, fmt.Errorf("provider %s gave no usable email: %w", provider, err)
+	}
+
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	user, err := qtx.UserLogin(ctx, identity.Email)
+	switch {
+	case err == nil:
+		// Linking on an unverified email would let anyone who can create
+		// an account at the provider take over the Chirpy account.
+		if !identity.EmailVerified {
+			return database.User{}, errIdentityEmailTaken
+		}
+		// Nor was the local account's email ever proven, so whoever
+		// registered it may not own the address; that includes legacy
+		// accounts from before verification. The provider has shown this
+		// user does, so the account becomes theirs alone.
+		if !user.EmailVerifiedAt.Valid {
+			if err := cfg.revokeUnverifiedCredentials(ctx, qtx, user.ID); err != nil {
+				return database.User{}, err
+			}
+			log.Printf("Revoked credentials of unverified user %s on linking %s identity", user.ID, provider)
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// SSO users have no Chirpy password until they reset one.
+		password, err := oidc.RandomValue()
+		if err != nil {
+			return database.User{}, err
+		}
+		hashedPassword, err := cfg.passwordParams.Hash(password)
+		if err != nil {
+			return database.User{}, err
+		}
+		user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:          identity.Email,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	default:
+		return database.User{}, err
+	}
+
+	if identity.EmailVerified && !user.EmailVerifiedAt.Valid {
+		err := qtx.MarkEmailVerified(ctx, database.MarkEmailVerifiedParams{ID: user.ID, Email: user.Email})
+		if err != nil {
+			return database.User{}, err
+		}
+		user.EmailVerifiedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		UserID:   user.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	return user, nil
+}
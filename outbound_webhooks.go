@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+const (
+	maxWebhookEndpoints           = 10
+	maxWebhookURLLength           = 2048
+	defaultWebhookDeliveriesShown = 100
+)
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID  `json:"id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Enabled             bool       `json:"enabled"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	ConsecutiveFailures int32      `json:"consecutive_failures"`
+	// Secret is only ever returned once, when the endpoint is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+func webhookEndpoint(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	resp := WebhookEndpoint{
+		ID:                  endpoint.ID,
+		CreatedAt:           endpoint.CreatedAt,
+		UpdatedAt:           endpoint.UpdatedAt,
+		URL:                 endpoint.Url,
+		Events:              endpoint.Events,
+		Enabled:             !endpoint.DisabledAt.Valid,
+		DisabledReason:      endpoint.DisabledReason,
+		ConsecutiveFailures: endpoint.ConsecutiveFailures,
+	}
+	if endpoint.DisabledAt.Valid {
+		resp.DisabledAt = &endpoint.DisabledAt.Time
+	}
+	return resp
+}
+
+type OutboundWebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int32          `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func outboundWebhookDelivery(delivery database.OutboundWebhookDelivery) OutboundWebhookDelivery {
+	resp := OutboundWebhookDelivery{
+		ID:        delivery.ID,
+		CreatedAt: delivery.CreatedAt,
+		EventID:   delivery.EventID,
+		EventType: delivery.EventType,
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		Error:     delivery.Error,
+		Payload:   json.RawMessage(delivery.Payload),
+	}
+	if delivery.Status == outboundPending {
+		resp.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.LastAttemptAt.Valid {
+		resp.LastAttemptAt = &delivery.LastAttemptAt.Time
+	}
+	if delivery.ResponseStatus.Valid {
+		resp.ResponseStatus = &delivery.ResponseStatus.Int32
+	}
+	return resp
+}
+
+// validateWebhookEndpoint returns a message for the client if an endpoint's
+// URL or events aren't acceptable, and otherwise tidies events.
+func (cfg *apiConfig) validateWebhookEndpoint(rawURL string, events []string) ([]string, string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || u.User != nil || len(rawURL) > maxWebhookURLLength {
+		return nil, "URL must be an absolute http(s) URL without credentials."
+	}
+	// Plain http is only for trying things out locally.
+	if u.Scheme != "https" && !(u.Scheme == "http" && cfg.platform == "dev") {
+		return nil, "URL must use https."
+	}
+
+	if len(events) == 0 {
+		return nil, "At least one event is required."
+	}
+	for _, event := range events {
+		if !slices.Contains(webhookEventTypes, event) {
+			return nil, "Unknown event: " + event
+		}
+	}
+	events = slices.Clone(events)
+	slices.Sort(events)
+	return slices.Compact(events), ""
+}
+
+// webhookEndpointFromPath loads the endpoint named in the path, if the caller
+// owns it, or responds with an error.
+func (cfg *apiConfig) webhookEndpointFromPath(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.WebhookEndpoint, bool) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID.", err)
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.DB.GetWebhookEndpoint(r.Context(), database.GetWebhookEndpointParams{
+		ID:     endpointID,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint does not exist.", err)
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook endpoint.", err)
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+func (cfg *apiConfig) handlerCreateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	events, msg := cfg.validateWebhookEndpoint(params.URL, params.Events)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	existing, err := cfg.DB.ListWebhookEndpoints(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook endpoints.", err)
+		return
+	}
+	if len(existing) >= maxWebhookEndpoints {
+		respondWithError(w, http.StatusBadRequest, "You can have at most "+strconv.Itoa(maxWebhookEndpoints)+" webhook endpoints.", nil)
+		return
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating webhook secret.", err)
+		return
+	}
+	encrypted, err := auth.EncryptSecret(secret, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating webhook secret.", err)
+		return
+	}
+
+	endpoint, err := cfg.DB.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		UserID:          caller.UserID,
+		Url:             params.URL,
+		EncryptedSecret: encrypted,
+		Events:          events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating webhook endpoint.", err)
+		return
+	}
+
+	resp := webhookEndpoint(endpoint)
+	resp.Secret = secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerListWebhookEndpoints(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	endpoints, err := cfg.DB.ListWebhookEndpoints(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook endpoints.", err)
+		return
+	}
+
+	resp := make([]WebhookEndpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		resp = append(resp, webhookEndpoint(endpoint))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerUpdateWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpoint, ok := cfg.webhookEndpointFromPath(w, r, caller.UserID)
+	if !ok {
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	events, msg := cfg.validateWebhookEndpoint(params.URL, params.Events)
+	if msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+
+	endpoint, err = cfg.DB.UpdateWebhookEndpoint(r.Context(), database.UpdateWebhookEndpointParams{
+		ID:     endpoint.ID,
+		UserID: caller.UserID,
+		Url:    params.URL,
+		Events: events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating webhook endpoint.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookEndpoint(endpoint))
+}
+
+// handlerEnableWebhookEndpoint turns an endpoint back on after it was
+// switched off for failing. Deliveries it missed meanwhile go out again.
+func (cfg *apiConfig) handlerEnableWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpoint, ok := cfg.webhookEndpointFromPath(w, r, caller.UserID)
+	if !ok {
+		return
+	}
+
+	if err := cfg.DB.EnableWebhookEndpoint(r.Context(), endpoint.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error enabling webhook endpoint.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerDeleteWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("endpointID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid endpoint ID.", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	deleted, err := cfg.DB.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:     endpointID,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error deleting webhook endpoint.", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint does not exist.", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// handlerListWebhookEndpointDeliveries is an endpoint's delivery log, newest
+// first.
+func (cfg *apiConfig) handlerListWebhookEndpointDeliveries(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpoint, ok := cfg.webhookEndpointFromPath(w, r, caller.UserID)
+	if !ok {
+		return
+	}
+
+	deliveries, err := cfg.DB.ListEndpointWebhookDeliveries(r.Context(), database.ListEndpointWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      defaultWebhookDeliveriesShown,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook deliveries.", err)
+		return
+	}
+
+	resp := make([]OutboundWebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, outboundWebhookDelivery(delivery))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerTestWebhookEndpoint queues a webhook.test event for an endpoint, so
+// integrators can check they receive and verify deliveries.
+func (cfg *apiConfig) handlerTestWebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpoint, ok := cfg.webhookEndpointFromPath(w, r, caller.UserID)
+	if !ok {
+		return
+	}
+	if endpoint.DisabledAt.Valid {
+		respondWithError(w, http.StatusConflict, "Webhook endpoint is disabled; enable it first.", nil)
+		return
+	}
+
+	eventID := uuid.New()
+	payload, err := newWebhookPayload(eventID, webhookTest, map[string]string{
+		"message": "This is a test event from Chirpy.",
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating test event.", err)
+		return
+	}
+	delivery, err := cfg.DB.EnqueueWebhookDelivery(r.Context(), database.EnqueueWebhookDeliveryParams{
+		EndpointID: endpoint.ID,
+		EventID:    eventID,
+		EventType:  webhookTest,
+		Payload:    payload,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Test event is already queued.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error queueing test event.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, outboundWebhookDelivery(delivery))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/outbox"
+	"github.com/google/uuid"
+)
+
+// aggregateChirp is the outbox aggregate of chirp events.
+const aggregateChirp = "chirp"
+
+const (
+	outboxBatch = 100
+	// outboxRetention is how long published messages are kept, for
+	// looking into what happened.
+	outboxRetention = 24 * time.Hour
+	// maxOutboxAttempts gives subscribers a couple of hours, with backoff,
+	// to recover before a message is given up on.
+	maxOutboxAttempts    = 20
+	maxOutboxErrorLength = 500
+)
+
+// outboxEventNamespace derives stable event IDs from outbox message IDs, so
+// a message published twice carries the same ID both times.
+var outboxEventNamespace = uuid.MustParse("3b1f6c0e-5a4d-4a63-9d0e-2f8f1c7b9a52")
+
+type chirpEvent struct {
+	Chirp database.Chirp `json:"chirp"`
+}
+
+// enqueueOutbox writes a message to the outbox with the caller's
+// transaction, so it is published if and only if the change it describes
+// commits.
+func enqueueOutbox(ctx context.Context, qtx *database.Queries, aggregateType, aggregateID, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return qtx.CreateOutboxMessage(ctx, database.CreateOutboxMessageParams{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		Payload:       string(data),
+	})
+}
+
+// outboxEventID is a stable ID for whatever a message causes, for consumers
+// that want to ignore repeats.
+func outboxEventID(msg outbox.Message) uuid.UUID {
+	return uuid.NewSHA1(outboxEventNamespace, []byte(strconv.FormatInt(msg.ID, 10)))
+}
+
+// subscribeOutbox wires up what happens when each kind of change commits.
+func (cfg *apiConfig) subscribeOutbox(bus *outbox.Bus) {
+	bus.Subscribe(webhookChirpCreated, cfg.sendChirpWebhooks)
+	bus.Subscribe(webhookChirpDeleted, cfg.sendChirpWebhooks)
+}
+
+func (cfg *apiConfig) sendChirpWebhooks(ctx context.Context, msg outbox.Message) error {
+	event := chirpEvent{}
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		return err
+	}
+	return cfg.publishWebhookEvent(ctx, event.Chirp.UserID, msg.Type, outboxEventID(msg), event.Chirp)
+}
+
+// runOutboxRelay publishes outbox messages and clears out old published
+// ones. Several servers can run it at once.
+func (cfg *apiConfig) runOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.relayOutbox(ctx); err != nil {
+			log.Printf("Error relaying outbox: %s", err)
+		}
+		deleted, err := cfg.DB.DeletePublishedOutboxMessages(ctx, sql.NullTime{Time: time.Now().UTC().Add(-outboxRetention), Valid: true})
+		if err != nil {
+			log.Printf("Error deleting published outbox messages: %s", err)
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d published outbox messages", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relayOutbox publishes every message that is due, a batch at a time.
+func (cfg *apiConfig) relayOutbox(ctx context.Context) error {
+	for {
+		relayed, err := cfg.relayOutboxBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if relayed < outboxBatch {
+			return nil
+		}
+	}
+}
+
+// relayOutboxBatch claims and publishes one batch. Claimed rows stay locked
+// until the batch is done, so no other relay publishes them, or anything
+// after them about the same aggregate, meanwhile.
+func (cfg *apiConfig) relayOutboxBatch(ctx context.Context) (int, error) {
+	tx, err := cfg.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	messages, err := qtx.ClaimOutboxMessages(ctx, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range messages {
+		msg := outbox.Message{
+			ID:            m.ID,
+			AggregateType: m.AggregateType,
+			AggregateID:   m.AggregateID,
+			Type:          m.EventType,
+			Payload:       []byte(m.Payload),
+			CreatedAt:     m.CreatedAt,
+		}
+		if pubErr := cfg.outbox.Publish(ctx, msg); pubErr != nil {
+			log.Printf("Error publishing outbox message %d: %s", m.ID, pubErr)
+			lastError := pubErr.Error()
+			if len(lastError) > maxOutboxErrorLength {
+				lastError = lastError[:maxOutboxErrorLength]
+			}
+			attempts := int(m.Attempts) + 1
+			failure := database.RecordOutboxFailureParams{
+				ID:            m.ID,
+				NextAttemptAt: time.Now().UTC().Add(outbox.RetryDelay(attempts)),
+				LastError:     lastError,
+			}
+			if attempts >= maxOutboxAttempts {
+				log.Printf("Giving up on outbox message %d after %d attempts", m.ID, attempts)
+				failure.FailedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+			}
+			err = qtx.RecordOutboxFailure(ctx, failure)
+		} else {
+			err = qtx.MarkOutboxMessagePublished(ctx, m.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), tx.Commit()
+}
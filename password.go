@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/mailer"
+)
+
+const passwordResetExpiry = 30 * time.Minute
+
+func (cfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Always answer the same way, and do the lookup and mailing off the
+	// request path, so the response reveals nothing about which emails exist.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Minute)
+	go func() {
+		defer cancel()
+		if err := cfg.sendPasswordResetEmail(ctx, params.Email); err != nil {
+			log.Printf("Error sending password reset email: %s", err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, nil)
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(ctx context.Context, email string) error {
+	user, err := cfg.DB.UserLogin(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Unknown email: nothing to send.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.DB.CreatePasswordReset(ctx, database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(passwordResetExpiry),
+		UserID:    user.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		From:    cfg.mailFrom,
+		To:      []string{user.Email},
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for this Chirpy account. If it was you, send the token below with your new password to POST /api/password/reset. It expires in %s and can only be used once.\n\n%s\n\nIf it wasn't you, you can ignore this email.\n",
+			passwordResetExpiry, token),
+	})
+}
+
+func (cfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := cfg.passwordPolicy.check(params.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password not allowed: "+err.Error()+".", err)
+		return
+	}
+
+	// Look up Reset Token
+	tokenHash := auth.HashToken(params.Token)
+	reset, err := cfg.DB.GetPasswordReset(r.Context(), tokenHash)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token.", err)
+		return
+	}
+	if reset.UsedAt.Valid || reset.ExpiresAt.Before(time.Now().UTC()) {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token.", nil)
+		return
+	}
+
+	hashedPassword, err := cfg.passwordParams.Hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
+		return
+	}
+
+	// Consume the token, set the password and sign out every session together.
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	used, err := qtx.UsePasswordReset(r.Context(), tokenHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+	if used == 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired reset token.", nil)
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             reset.UserID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+
+	if err := qtx.InvalidatePasswordResets(r.Context(), reset.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+
+	if err := qtx.RevokeAllRefreshTokensForUser(r.Context(), reset.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error resetting password.", err)
+		return
+	}
+	cfg.audit(r, auditEvent{Action: auditPasswordReset, Outcome: auditSuccess, ActorID: reset.UserID, UserID: reset.UserID, Detail: "all sessions revoked"})
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
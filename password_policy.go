@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/config"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+var errBreachedPassword = errors.New("it appears in a list of breached passwords")
+
+// passwordPolicy decides which new passwords are acceptable. It applies when
+// a password is set, never at login, so existing weak passwords keep
+// working until they are changed.
+type passwordPolicy struct {
+	minLength int
+	// breached holds known-compromised passwords, lowercased.
+	breached map[string]struct{}
+}
+
+// check returns an error, phrased to follow "Password not allowed: ", if
+// password is too weak.
+func (p passwordPolicy) check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return fmt.Errorf("it must be at least %d characters long", p.minLength)
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		return errBreachedPassword
+	}
+	return nil
+}
+
+func readPasswordList(r io.Reader, into map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			into[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// configurePasswordPolicy applies passwords.min_length and reads
+// passwords.breached_file, a file of one password per line checked on top of
+// the bundled list of common passwords.
+func configurePasswordPolicy(conf config.Passwords) (passwordPolicy, error) {
+	policy := passwordPolicy{minLength: conf.MinLength, breached: map[string]struct{}{}}
+
+	if err := readPasswordList(strings.NewReader(commonPasswords), policy.breached); err != nil {
+		return passwordPolicy{}, err
+	}
+	if path := conf.BreachedFile; path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return passwordPolicy{}, err
+		}
+		defer f.Close()
+		if err := readPasswordList(f, policy.breached); err != nil {
+			return passwordPolicy{}, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return policy, nil
+}
+
+// configurePasswordHashing sets the Argon2id cost of new password hashes
+// from the passwords.argon2_* settings, which config has range-checked.
+func configurePasswordHashing(conf config.Passwords) auth.Argon2Params {
+	params := auth.DefaultArgon2Params
+	params.Memory = uint32(conf.Argon2MemoryKiB)
+	params.Iterations = uint32(conf.Argon2Iterations)
+	params.Parallelism = uint8(conf.Argon2Parallelism)
+	return params
+}
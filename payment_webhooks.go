@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/config"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/payments"
+	"github.com/google/uuid"
+)
+
+const maxWebhookBodyBytes = 1 << 20
+
+// Webhook delivery statuses, as stored in webhook_deliveries.status.
+// Deliveries that failed for reasons on our side are dead-lettered until
+// they are replayed, or until the provider's own retry of the event
+// succeeds and resolves them.
+const (
+	webhookPending    = "pending"
+	webhookProcessed  = "processed"
+	webhookDuplicate  = "duplicate"
+	webhookRejected   = "rejected"
+	webhookDeadLetter = "dead_letter"
+	webhookResolved   = "resolved"
+)
+
+// configurePaymentProviders sets up every payment provider with a webhook
+// secret: Polka from payments.polka_key and Stripe from
+// payments.stripe_webhook_secret. Webhooks for any other provider are
+// refused.
+func configurePaymentProviders(conf config.Payments) (map[string]payments.Provider, error) {
+	providers := map[string]payments.Provider{}
+	for _, setup := range []struct {
+		secret config.Secret
+		new    func(secret string) (payments.Provider, error)
+	}{
+		{conf.PolkaKey, func(secret string) (payments.Provider, error) { return payments.NewPolka(secret) }},
+		{conf.StripeWebhookSecret, func(secret string) (payments.Provider, error) { return payments.NewStripe(secret) }},
+	} {
+		secret := setup.secret.Reveal()
+		if secret == "" {
+			continue
+		}
+		provider, err := setup.new(secret)
+		if err != nil {
+			return nil, err
+		}
+		providers[provider.Name()] = provider
+	}
+	return providers, nil
+}
+
+// handlerPaymentWebhook receives membership events from the payment provider
+// named in the path. Each must pass the provider's signature check and carry
+// an event id; an id seen before is acknowledged without being processed
+// again, since providers retry until they get a 2xx. Every request is kept
+// in webhook_deliveries, though only the fact of an unsigned one is: its
+// headers and body could be anything anyone cared to send.
+func (cfg *apiConfig) handlerPaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.paymentProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown payment provider.", nil)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read request body.", err)
+		return
+	}
+
+	// Verify Signature
+	if err := provider.VerifyWebhook(r.Header, body, time.Now()); err != nil {
+		cfg.recordRejectedWebhook(r.Context(), provider.Name(), err)
+		respondWithError(w, http.StatusUnauthorized, "Invalid webhook signature.", err)
+		return
+	}
+
+	headers, err := json.Marshal(webhookHeaders(r.Header))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing webhook.", err)
+		return
+	}
+	delivery, err := cfg.DB.CreateWebhookDelivery(r.Context(), database.CreateWebhookDeliveryParams{
+		Provider: provider.Name(),
+		Headers:  string(headers),
+		Body:     string(body),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing webhook.", err)
+		return
+	}
+
+	_, err = cfg.processPaymentDelivery(r, provider, delivery)
+	switch {
+	case errors.Is(err, payments.ErrMalformedEvent):
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+	case errors.Is(err, errMembershipUserNotFound):
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, "Error processing webhook.", err)
+	default:
+		respondWithJSON(w, http.StatusNoContent, nil)
+	}
+}
+
+// handlerPolkaWebhook keeps the URL Polka was first set up with working.
+func (cfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	r.SetPathValue("provider", "polka")
+	cfg.handlerPaymentWebhook(w, r)
+}
+
+// processPaymentDelivery acts on a delivery whose signature has been
+// checked, records how that went on the delivery and returns its new status.
+func (cfg *apiConfig) processPaymentDelivery(r *http.Request, provider payments.Provider, delivery database.WebhookDelivery) (string, error) {
+	event, err := provider.ParseEvent([]byte(delivery.Body))
+	if err != nil {
+		cfg.recordWebhookAttempt(r.Context(), delivery.ID, event, webhookRejected, err)
+		return webhookRejected, err
+	}
+
+	status, userID, err := cfg.applyPaymentEvent(r, provider, event, delivery.Body)
+	if errors.Is(err, errMembershipUserNotFound) {
+		cfg.audit(r, auditEvent{Action: auditMembershipChange, Outcome: auditFailure, UserID: userID, Detail: provider.Name() + " " + event.ProviderType + " " + event.ID + ": unknown user"})
+	}
+	if err != nil {
+		status = webhookDeadLetter
+	}
+	if status == webhookDuplicate {
+		log.Printf("Ignoring duplicate %s event %s", provider.Name(), event.ID)
+	}
+	cfg.recordWebhookAttempt(r.Context(), delivery.ID, event, status, err)
+
+	// Once an event has gone through, earlier failed deliveries of it need
+	// no more attention.
+	if status == webhookProcessed {
+		err := cfg.DB.ResolveDeadLetterWebhookDeliveries(r.Context(), database.ResolveDeadLetterWebhookDeliveriesParams{
+			Provider: provider.Name(),
+			EventID:  event.ID,
+		})
+		if err != nil {
+			log.Printf("Error resolving dead-lettered deliveries of %s event %s: %s", provider.Name(), event.ID, err)
+		}
+	}
+	return status, err
+}
+
+// applyPaymentEvent processes an event unless it has been already, and
+// returns the user it was for, if known.
+func (cfg *apiConfig) applyPaymentEvent(r *http.Request, provider payments.Provider, event payments.Event, payload string) (string, uuid.UUID, error) {
+	// Recording the event and acting on it commit together, so an event
+	// that fails is retried rather than remembered as done.
+	tx, err := cfg.dbConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return "", event.UserID, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.DB.WithTx(tx)
+
+	recorded, err := qtx.RecordWebhookEvent(r.Context(), database.RecordWebhookEventParams{
+		Provider:  provider.Name(),
+		EventID:   event.ID,
+		EventType: event.ProviderType,
+		Payload:   payload,
+	})
+	if err != nil {
+		return "", event.UserID, err
+	}
+	if recorded == 0 {
+		return webhookDuplicate, event.UserID, nil
+	}
+
+	userID, err := resolvePaymentCustomer(r.Context(), qtx, provider.Name(), event)
+	if err != nil {
+		return "", userID, err
+	}
+
+	// Event types that don't change a membership are recorded and
+	// acknowledged, so the provider stops sending them.
+	changed := false
+	if event.Type != "" {
+		if userID == uuid.Nil {
+			return "", userID, errMembershipUserNotFound
+		}
+		changed, err = applyMembershipChange(r.Context(), qtx, membershipChange{
+			UserID:          userID,
+			Event:           event.Type,
+			Source:          provider.Name(),
+			ProviderEventID: event.ID,
+			PeriodEnd:       event.PeriodEnd,
+		}, time.Now().UTC())
+		if err != nil {
+			return "", userID, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", userID, err
+	}
+	if changed {
+		cfg.audit(r, auditEvent{Action: auditMembershipChange, Outcome: auditSuccess, UserID: userID, Detail: provider.Name() + " " + event.ProviderType + " " + event.ID})
+	}
+	return webhookProcessed, userID, nil
+}
+
+// resolvePaymentCustomer works out which user an event is for. An event
+// naming both a user and a customer links them, so later events that name
+// only the customer can be matched. It returns uuid.Nil if there's no
+// telling.
+func resolvePaymentCustomer(ctx context.Context, qtx *database.Queries, provider string, event payments.Event) (uuid.UUID, error) {
+	if event.UserID != uuid.Nil {
+		if event.CustomerID == "" {
+			return event.UserID, nil
+		}
+		if _, err := qtx.GetUserByID(ctx, event.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return event.UserID, errMembershipUserNotFound
+			}
+			return event.UserID, err
+		}
+		return event.UserID, qtx.LinkPaymentCustomer(ctx, database.LinkPaymentCustomerParams{
+			Provider:   provider,
+			CustomerID: event.CustomerID,
+			UserID:     event.UserID,
+		})
+	}
+
+	if event.CustomerID == "" {
+		return uuid.Nil, nil
+	}
+	userID, err := qtx.GetPaymentCustomerUser(ctx, database.GetPaymentCustomerUserParams{
+		Provider:   provider,
+		CustomerID: event.CustomerID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return userID, err
+}
+
+// recordWebhookAttempt stores the outcome of trying a delivery. Failing to is
+// logged; the webhook has been handled either way.
+func (cfg *apiConfig) recordWebhookAttempt(ctx context.Context, deliveryID uuid.UUID, event payments.Event, status string, attemptErr error) {
+	errText := ""
+	if attemptErr != nil {
+		errText = attemptErr.Error()
+	}
+	_, err := cfg.DB.RecordWebhookDeliveryAttempt(context.WithoutCancel(ctx), database.RecordWebhookDeliveryAttemptParams{
+		ID:        deliveryID,
+		Status:    status,
+		EventID:   event.ID,
+		EventType: event.ProviderType,
+		Error:     errText,
+	})
+	if err != nil {
+		log.Printf("Error recording attempt at webhook delivery %s: %s", deliveryID, err)
+	}
+}
+
+// recordRejectedWebhook notes a request whose signature didn't check out,
+// without its headers or body. Failing to is logged.
+func (cfg *apiConfig) recordRejectedWebhook(ctx context.Context, provider string, verifyErr error) {
+	delivery, err := cfg.DB.CreateWebhookDelivery(context.WithoutCancel(ctx), database.CreateWebhookDeliveryParams{
+		Provider: provider,
+		Headers:  "{}",
+		Body:     "",
+	})
+	if err != nil {
+		log.Printf("Error recording rejected webhook: %s", err)
+		return
+	}
+	cfg.recordWebhookAttempt(ctx, delivery.ID, payments.Event{}, webhookRejected, verifyErr)
+}
+
+// webhookHeaders is what's kept of a webhook's headers: everything but
+// credentials.
+func webhookHeaders(h http.Header) http.Header {
+	kept := h.Clone()
+	for _, name := range []string{"Authorization", "Cookie", "Proxy-Authorization"} {
+		kept.Del(name)
+	}
+	return kept
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPersonalAccessTokenExpiry = 90 * 24 * time.Hour
+	maxPersonalAccessTokenExpiry     = 365 * 24 * time.Hour
+)
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Token is only ever returned once, when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func personalAccessToken(pat database.PersonalAccessToken) PersonalAccessToken {
+	resp := PersonalAccessToken{
+		ID:        pat.ID,
+		CreatedAt: pat.CreatedAt,
+		Name:      pat.Name,
+		Scopes:    pat.Scopes,
+		ExpiresAt: pat.ExpiresAt,
+	}
+	if pat.LastUsedAt.Valid {
+		resp.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return resp
+}
+
+func (cfg *apiConfig) handlerCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > 100 {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters.", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one scope is required.", nil)
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(validScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, "Unknown scope: "+scope, nil)
+			return
+		}
+	}
+	slices.Sort(params.Scopes)
+	params.Scopes = slices.Compact(params.Scopes)
+
+	expiresIn := defaultPersonalAccessTokenExpiry
+	if params.ExpiresInDays != 0 {
+		expiresIn = time.Duration(params.ExpiresInDays) * 24 * time.Hour
+	}
+	if expiresIn <= 0 || expiresIn > maxPersonalAccessTokenExpiry {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must be between 1 and 365.", nil)
+		return
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating token.", err)
+		return
+	}
+
+	pat, err := cfg.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		Name:      params.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    params.Scopes,
+		ExpiresAt: time.Now().UTC().Add(expiresIn),
+		UserID:    caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating token.", err)
+		return
+	}
+
+	resp := personalAccessToken(pat)
+	resp.Token = token
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) handlerListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	pats, err := cfg.DB.ListPersonalAccessTokens(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving tokens.", err)
+		return
+	}
+
+	resp := make([]PersonalAccessToken, 0, len(pats))
+	for _, pat := range pats {
+		resp = append(resp, personalAccessToken(pat))
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerRevokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID.", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	revoked, err := cfg.DB.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking token.", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Token does not exist.", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
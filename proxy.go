@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies reads a comma-separated list of the networks that
+// load balancers or reverse proxies in front of Chirpy connect from, such as
+// "10.0.0.0/8, 192.168.1.10". A bare address is a network of one.
+func parseTrustedProxies(v string) ([]netip.Prefix, error) {
+	var trusted []netip.Prefix
+	for _, field := range strings.Split(v, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			trusted = append(trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+func isTrustedProxy(trusted []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// middlewareClientIP attributes a request that came through a trusted proxy
+// to the client the proxy reports, so clientIP sees users rather than the
+// load balancer. Without it every user would share the proxy's address, and
+// the per-IP login throttle would lock them all out at once.
+func middlewareClientIP(trusted []netip.Prefix, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client := forwardedClientIP(trusted, r); client.IsValid() {
+			r.RemoteAddr = client.String()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClientIP reads X-Forwarded-For from the right, skipping trusted
+// proxies. Entries further left were written by the client and can't be
+// believed.
+func forwardedClientIP(trusted []netip.Prefix, r *http.Request) netip.Addr {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil || !isTrustedProxy(trusted, peer.Addr()) {
+		return netip.Addr{}
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}
+		}
+		if !isTrustedProxy(trusted, addr) {
+			return addr.Unmap()
+		}
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Roles a user can hold, stored in users.role and carried in the role claim
+// of access tokens from a login.
+const (
+	roleUser      = "user"
+	roleModerator = "moderator"
+	roleAdmin     = "admin"
+)
+
+var validRoles = []string{roleUser, roleModerator, roleAdmin}
+
+type permission string
+
+const (
+	permViewMetrics    permission = "metrics:view"
+	permResetDatabase  permission = "database:reset"
+	permManageRoles    permission = "roles:manage"
+	permDeleteAnyChirp permission = "chirps:delete-any"
+	permUnlockAccounts permission = "accounts:unlock"
+	permViewAuditLog   permission = "audit:view"
+	permImpersonate    permission = "users:impersonate"
+	permViewMembership permission = "memberships:view"
+	permManageWebhooks permission = "webhooks:manage"
+	permViewJobs       permission = "jobs:view"
+)
+
+var rolePermissions = map[string][]permission{
+	roleModerator: {permViewMetrics, permDeleteAnyChirp, permUnlockAccounts, permViewMembership},
+	roleAdmin:     {permViewMetrics, permResetDatabase, permManageRoles, permDeleteAnyChirp, permUnlockAccounts, permViewAuditLog, permImpersonate, permViewMembership, permManageWebhooks, permViewJobs},
+}
+
+func hasPermission(role string, perm permission) bool {
+	return slices.Contains(rolePermissions[role], perm)
+}
+
+type principalContextKey struct{}
+
+// principalFromContext returns the caller stored by
+// middlewareRequirePermission.
+func principalFromContext(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// middlewareRequirePermission lets a request through only if its access
+// token is from a login whose role grants perm. Personal access tokens and
+// OAuth clients never carry a role.
+func (cfg *apiConfig) middlewareRequirePermission(perm permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := cfg.authenticate(r, scopeSessionOnly)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if !hasPermission(caller.Role, perm) {
+			respondWithError(w, http.StatusForbidden, "You don't have permission to do that.", nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, caller)))
+	})
+}
+
+// handlerSetUserRole changes a user's role. It reaches their access tokens
+// on the next refresh, within the hour.
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID.", err)
+		return
+	}
+	caller, _ := principalFromContext(r.Context())
+	// Keeps the last admin from locking everyone out.
+	if userID == caller.UserID {
+		respondWithError(w, http.StatusBadRequest, "You can't change your own role.", nil)
+		return
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !slices.Contains(validRoles, params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be one of user, moderator or admin.", nil)
+		return
+	}
+
+	updated, err := cfg.DB.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: params.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error updating role.", err)
+		return
+	}
+	if updated == 0 {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", nil)
+		return
+	}
+	cfg.audit(r, auditEvent{Action: auditRoleChange, Outcome: auditSuccess, ActorID: caller.UserID, UserID: userID, Detail: "role set to " + params.Role})
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
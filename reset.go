@@ -2,20 +2,22 @@ package main
 
 import (
 	"net/http"
-	"os"
 )
 
+// handlerReset wipes the database. Besides the database:reset permission it
+// only ever runs on a dev deployment.
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	//TODO: should the below be "dev" or is the os.Getenv() call correct?
-
+	caller, _ := principalFromContext(r.Context())
 	w.Header().Set("Content-Type", "application/json")
-	if cfg.platform != os.Getenv("PLATFORM") {
+	if cfg.platform != "dev" {
+		cfg.audit(r, auditEvent{Action: auditAdminReset, Outcome: auditFailure, ActorID: caller.UserID, Detail: "refused outside dev"})
 		w.WriteHeader(http.StatusForbidden)
-		w.Write([]byte("Reset requires admin privleges"))
+		w.Write([]byte("Reset is only allowed in development"))
 		return
 	}
 	cfg.fileserverHits.Store(0)
 	cfg.DB.DeleteAllUsers(r.Context())
+	cfg.audit(r, auditEvent{Action: auditAdminReset, Outcome: auditSuccess, ActorID: caller.UserID, Detail: "all users deleted"})
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Hits reset to 0. Database reset to initial state."))
 }
\ No newline at end of file
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// refreshTokenPurgeBatch bounds each delete, so purging a large backlog
+// never holds locks on many rows at once.
+const refreshTokenPurgeBatch = 1000
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+}
+
+// clientIP returns the address of the connecting peer, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) handlerListSessions(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	rows, err := cfg.DB.ListActiveSessions(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving sessions.", err)
+		return
+	}
+
+	sessions := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, Session{
+			ID:         row.FamilyID,
+			StartedAt:  row.StartedAt,
+			LastUsedAt: row.LastUsedAt,
+			ExpiresAt:  row.ExpiresAt,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			Current:    row.FamilyID == caller.SessionID,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID.", err)
+		return
+	}
+
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	revoked, err := cfg.DB.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking session.", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session does not exist.", nil)
+		return
+	}
+	cfg.audit(r, auditEvent{Action: auditTokenRevoke, Outcome: auditSuccess, ActorID: userID, UserID: userID, Detail: "session " + sessionID.String()})
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	if caller.SessionID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Access token is not tied to a session.", nil)
+		return
+	}
+
+	err = cfg.DB.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+		UserID:   caller.UserID,
+		FamilyID: caller.SessionID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error revoking sessions.", err)
+		return
+	}
+	cfg.audit(r, auditEvent{Action: auditTokenRevoke, Outcome: auditSuccess, ActorID: caller.UserID, UserID: caller.UserID, Detail: "all sessions except " + caller.SessionID.String()})
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+// purgeRefreshTokens deletes refresh tokens that expired or were revoked
+// more than refreshTokenRetention ago, a batch at a time. It runs as a
+// recurring job.
+func (cfg *apiConfig) purgeRefreshTokens(ctx context.Context, _ struct{}) error {
+	cutoff := time.Now().UTC().Add(-cfg.refreshTokenRetention)
+
+	var purged int64
+	defer func() {
+		if purged > 0 {
+			log.Printf("Purged %d expired or revoked refresh tokens", purged)
+		}
+	}()
+	for {
+		n, err := cfg.DB.PurgeRefreshTokens(ctx, database.PurgeRefreshTokensParams{
+			Cutoff:    cutoff,
+			BatchSize: refreshTokenPurgeBatch,
+		})
+		if err != nil {
+			return err
+		}
+		purged += n
+		cfg.refreshTokensPurged.Add(n)
+		if n < refreshTokenPurgeBatch {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,31 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, action, outcome, actor_id, user_id, detail, ip_address, user_agent, request_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('action')::TEXT IS NULL OR action = sqlc.narg('action'))
+    AND (sqlc.narg('outcome')::TEXT IS NULL OR outcome = sqlc.narg('outcome'))
+    AND (sqlc.narg('actor_id')::UUID IS NULL OR actor_id = sqlc.narg('actor_id'))
+    AND (sqlc.narg('user_id')::UUID IS NULL OR user_id = sqlc.narg('user_id'))
+    AND (sqlc.narg('since')::TIMESTAMP IS NULL OR created_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::TIMESTAMP IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListUserAuditEvents :many
+SELECT * FROM audit_events
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
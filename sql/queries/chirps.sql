@@ -0,0 +1,9 @@
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: CountUserChirpsSince :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1 AND created_at >= $2;
@@ -1,11 +1,15 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, user_agent, ip_address, last_used_at)
 VALUES (
     $1,
     NOW(),
     NOW(),
     NOW() + INTERVAL '60 days',
     NULL,
-    $2
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
 )
-RETURNING *;
\ No newline at end of file
+RETURNING *;
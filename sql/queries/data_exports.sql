@@ -0,0 +1,24 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, status, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    'pending',
+    $1
+)
+RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', file_path = $2, expires_at = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', error = $2, updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,26 @@
+-- name: CreateEmailVerification :one
+INSERT INTO email_verifications (id, created_at, expires_at, used_at, email, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    NULL,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetEmailVerification :one
+SELECT * FROM email_verifications
+WHERE id = $1;
+
+-- name: UseEmailVerification :execrows
+UPDATE email_verifications
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL;
+
+-- name: IsLegacyAccount :one
+SELECT EXISTS (
+    SELECT 1 FROM legacy_accounts
+    WHERE user_id = $1
+);
@@ -0,0 +1,65 @@
+-- name: EnqueueJob :one
+INSERT INTO jobs (id, created_at, updated_at, kind, payload, status, run_at, unique_key)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    'queued',
+    $3,
+    $4
+)
+ON CONFLICT (unique_key) WHERE unique_key IS NOT NULL DO NOTHING
+RETURNING *;
+
+-- name: ClaimJobs :many
+UPDATE jobs
+SET status = 'running', attempts = attempts + 1, locked_until = sqlc.arg(lease_until), updated_at = NOW()
+WHERE id IN (
+    SELECT id FROM jobs
+    WHERE kind = ANY(sqlc.arg(kinds)::TEXT[])
+        AND ((status = 'queued' AND run_at <= NOW())
+            OR (status = 'running' AND locked_until < NOW()))
+    ORDER BY run_at
+    LIMIT sqlc.arg(max_jobs)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteJob :exec
+UPDATE jobs
+SET status = 'succeeded', locked_until = NULL, finished_at = NOW(), last_error = '', updated_at = NOW()
+WHERE id = $1;
+
+-- name: RetryJob :exec
+UPDATE jobs
+SET status = 'queued', locked_until = NULL, run_at = $2, last_error = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: FailJob :exec
+UPDATE jobs
+SET status = 'failed', locked_until = NULL, finished_at = NOW(), last_error = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetJob :one
+SELECT * FROM jobs
+WHERE id = $1;
+
+-- name: ListJobs :many
+SELECT * FROM jobs
+WHERE (sqlc.narg('status')::TEXT IS NULL OR status = sqlc.narg('status'))
+    AND (sqlc.narg('kind')::TEXT IS NULL OR kind = sqlc.narg('kind'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: PurgeFinishedJobs :execrows
+-- Deletes up to batch_size jobs that succeeded or gave up before cutoff.
+DELETE FROM jobs
+WHERE id IN (
+    SELECT id FROM jobs
+    WHERE status IN ('succeeded', 'failed')
+        AND finished_at < sqlc.arg(cutoff)
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+);
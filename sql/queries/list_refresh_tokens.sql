@@ -0,0 +1,4 @@
+-- name: ListRefreshTokensForUser :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at;
@@ -0,0 +1,30 @@
+-- name: GetLoginThrottle :one
+SELECT * FROM login_throttles
+WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (key, failures, last_failed_at)
+VALUES (@key, 1, @last_failed_at)
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE
+        WHEN login_throttles.last_failed_at < @window_start THEN 1
+        ELSE login_throttles.failures + 1
+    END,
+    last_failed_at = EXCLUDED.last_failed_at
+RETURNING *;
+
+-- name: LockLoginThrottle :exec
+UPDATE login_throttles
+SET locked_until = $2
+WHERE key = $1;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE key = $1;
+
+-- name: PurgeLoginThrottles :execrows
+-- Deletes throttles with no failure since cutoff and no lockout still
+-- running.
+DELETE FROM login_throttles
+WHERE last_failed_at < @cutoff
+    AND (locked_until IS NULL OR locked_until < NOW());
@@ -0,0 +1,48 @@
+-- name: GetMembership :one
+SELECT * FROM memberships
+WHERE user_id = $1;
+
+-- name: StartMembershipPeriod :one
+INSERT INTO memberships (user_id, created_at, updated_at, status, current_period_start, current_period_end)
+VALUES ($1, NOW(), NOW(), 'active', $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET status = 'active',
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    grace_until = NULL,
+    canceled_at = NULL,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: CancelMembership :one
+UPDATE memberships
+SET status = 'canceled', canceled_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND status IN ('active', 'past_due')
+RETURNING *;
+
+-- name: MarkMembershipPastDue :one
+UPDATE memberships
+SET status = 'past_due', grace_until = $2, updated_at = NOW()
+WHERE user_id = $1 AND status IN ('active', 'past_due')
+RETURNING *;
+
+-- name: EndMembership :one
+UPDATE memberships
+SET status = 'expired', grace_until = NULL, updated_at = NOW()
+WHERE user_id = $1 AND status <> 'expired'
+RETURNING *;
+
+-- name: ExpireMemberships :many
+UPDATE memberships
+SET status = 'expired', updated_at = NOW()
+WHERE status <> 'expired' AND COALESCE(grace_until, current_period_end) <= sqlc.arg(now)::TIMESTAMP
+RETURNING *;
+
+-- name: CreateMembershipEvent :exec
+INSERT INTO membership_events (id, created_at, user_id, event, source, provider_event_id, status, period_end)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6);
+
+-- name: ListMembershipEvents :many
+SELECT * FROM membership_events
+WHERE user_id = $1
+ORDER BY created_at DESC;
@@ -0,0 +1,51 @@
+-- name: UpsertPendingTOTP :one
+INSERT INTO user_totp (user_id, created_at, updated_at, encrypted_secret, confirmed_at, last_used_step)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    NULL,
+    0
+)
+ON CONFLICT (user_id) DO UPDATE
+SET encrypted_secret = EXCLUDED.encrypted_secret, updated_at = NOW(), last_used_step = 0
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTOTP :one
+SELECT * FROM user_totp
+WHERE user_id = $1;
+
+-- name: ConfirmTOTP :exec
+UPDATE user_totp
+SET confirmed_at = NOW(), updated_at = NOW(), last_used_step = $2
+WHERE user_id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2, updated_at = NOW()
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- name: DeleteTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, created_at, used_at, code_hash, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NULL,
+    $1,
+    $2
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
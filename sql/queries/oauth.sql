@@ -0,0 +1,88 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, name, secret_hash, redirect_uris, owner_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND owner_id = $2;
+
+-- name: CreateOAuthGrant :exec
+INSERT INTO oauth_grants (id, created_at, updated_at, scopes, client_id, user_id)
+VALUES (
+    $1,
+    NOW(),
+    NOW(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: GetOAuthGrant :one
+SELECT * FROM oauth_grants
+WHERE id = $1;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_grants
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllOAuthGrantsForUser :exec
+UPDATE oauth_grants
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, expires_at, redirect_uri, code_challenge, grant_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5
+);
+
+-- name: GetOAuthAuthorizationCode :one
+SELECT * FROM oauth_authorization_codes
+WHERE code_hash = $1;
+
+-- name: UseOAuthAuthorizationCode :execrows
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL;
+
+-- name: CreateOAuthRefreshToken :exec
+INSERT INTO oauth_refresh_tokens (token_hash, created_at, expires_at, grant_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3
+);
+
+-- name: GetOAuthRefreshToken :one
+SELECT * FROM oauth_refresh_tokens
+WHERE token_hash = $1;
+
+-- name: RotateOAuthRefreshToken :execrows
+UPDATE oauth_refresh_tokens
+SET rotated_at = NOW()
+WHERE token_hash = $1 AND rotated_at IS NULL;
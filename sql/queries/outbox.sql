@@ -0,0 +1,38 @@
+-- name: CreateOutboxMessage :exec
+INSERT INTO outbox (created_at, aggregate_type, aggregate_id, event_type, payload, next_attempt_at)
+VALUES (NOW(), $1, $2, $3, $4, NOW());
+
+-- name: ClaimOutboxMessages :many
+-- Only the oldest unpublished message of each aggregate is due, so messages
+-- are published in order; one given up on no longer holds the rest back.
+-- The row lock keeps other relays off it.
+SELECT * FROM outbox o
+WHERE o.published_at IS NULL
+    AND o.failed_at IS NULL
+    AND o.next_attempt_at <= NOW()
+    AND NOT EXISTS (
+        SELECT 1 FROM outbox earlier
+        WHERE earlier.aggregate_type = o.aggregate_type
+            AND earlier.aggregate_id = o.aggregate_id
+            AND earlier.published_at IS NULL
+            AND earlier.failed_at IS NULL
+            AND earlier.id < o.id
+    )
+ORDER BY o.id
+LIMIT $1
+FOR UPDATE SKIP LOCKED;
+
+-- name: MarkOutboxMessagePublished :exec
+UPDATE outbox
+SET published_at = NOW(), attempts = attempts + 1, last_error = ''
+WHERE id = $1;
+
+-- name: RecordOutboxFailure :exec
+-- Setting failed_at gives up on the message.
+UPDATE outbox
+SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3, failed_at = $4
+WHERE id = $1;
+
+-- name: DeletePublishedOutboxMessages :execrows
+DELETE FROM outbox
+WHERE published_at < $1;
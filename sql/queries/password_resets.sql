@@ -0,0 +1,23 @@
+-- name: CreatePasswordReset :exec
+INSERT INTO password_resets (token_hash, created_at, expires_at, used_at, user_id)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    NULL,
+    $3
+);
+
+-- name: GetPasswordReset :one
+SELECT * FROM password_resets
+WHERE token_hash = $1;
+
+-- name: UsePasswordReset :execrows
+UPDATE password_resets
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL;
+
+-- name: InvalidatePasswordResets :exec
+UPDATE password_resets
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
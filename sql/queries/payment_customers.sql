@@ -0,0 +1,8 @@
+-- name: LinkPaymentCustomer :exec
+INSERT INTO payment_customers (provider, customer_id, user_id, created_at)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (provider, customer_id) DO UPDATE SET user_id = EXCLUDED.user_id;
+
+-- name: GetPaymentCustomerUser :one
+SELECT user_id FROM payment_customers
+WHERE provider = $1 AND customer_id = $2;
@@ -0,0 +1,17 @@
+-- name: PurgeRefreshTokens :execrows
+-- Deletes up to batch_size tokens that expired or were revoked before
+-- cutoff, from families with no token still in use, so a live session keeps
+-- its history for reuse detection and its start time.
+DELETE FROM refresh_tokens
+WHERE token_hash IN (
+    SELECT t.token_hash FROM refresh_tokens t
+    WHERE (t.expires_at < sqlc.arg(cutoff) OR t.revoked_at < sqlc.arg(cutoff))
+        AND NOT EXISTS (
+            SELECT 1 FROM refresh_tokens live
+            WHERE live.family_id = t.family_id
+                AND live.revoked_at IS NULL
+                AND live.expires_at > NOW()
+        )
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+);
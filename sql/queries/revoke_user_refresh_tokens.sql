@@ -0,0 +1,4 @@
+-- name: RevokeAllRefreshTokensForUser :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
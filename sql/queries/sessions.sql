@@ -0,0 +1,24 @@
+-- name: ListActiveSessions :many
+SELECT
+    refresh_tokens.family_id,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = refresh_tokens.family_id)::TIMESTAMP AS started_at,
+    refresh_tokens.last_used_at,
+    refresh_tokens.expires_at,
+    refresh_tokens.user_agent,
+    refresh_tokens.ip_address
+FROM refresh_tokens
+WHERE refresh_tokens.user_id = $1
+    AND refresh_tokens.revoked_at IS NULL
+    AND refresh_tokens.rotated_at IS NULL
+    AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
@@ -1,4 +1,14 @@
 -- name: UpdateRefreshToken :exec
 UPDATE refresh_tokens
 SET updated_at = $2, revoked_at = $3
-WHERE token = $1;
\ No newline at end of file
+WHERE token_hash = $1;
+
+-- name: RotateRefreshToken :execrows
+UPDATE refresh_tokens
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE token_hash = $1 AND rotated_at IS NULL AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
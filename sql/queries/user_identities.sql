@@ -0,0 +1,21 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (id, created_at, updated_at, provider, subject, email, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1 AND subject = $2;
+
+-- name: UpdateUserIdentityEmail :exec
+UPDATE user_identities
+SET email = $2, updated_at = NOW()
+WHERE id = $1;
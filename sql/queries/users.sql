@@ -11,6 +11,53 @@ RETURNING *;
 
 -- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = $2,
+    hashed_password = $3,
+    updated_at = NOW(),
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: MarkEmailVerified :exec
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ScheduleUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: CancelUserDeletion :exec
+UPDATE users
+SET deletion_scheduled_at = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- name: PurgeScheduledUserDeletions :many
+DELETE FROM users
+WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1
+RETURNING id;
+
+-- name: SetUserRole :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: SetUserRoleByEmail :execrows
+UPDATE users
+SET role = $2, updated_at = NOW()
+WHERE email = $1;
+
+-- name: SetUserChirpyRed :exec
+UPDATE users
+SET is_chirpy_red = $2, updated_at = NOW()
+WHERE id = $1;
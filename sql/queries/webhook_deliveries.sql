@@ -0,0 +1,44 @@
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (id, provider, received_at, headers, body, status)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    NOW(),
+    $2,
+    $3,
+    'pending'
+)
+RETURNING *;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1;
+
+-- name: RecordWebhookDeliveryAttempt :one
+UPDATE webhook_deliveries
+SET status = $2, event_id = $3, event_type = $4, error = $5,
+    attempts = attempts + 1, last_attempt_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ResolveDeadLetterWebhookDeliveries :exec
+UPDATE webhook_deliveries
+SET status = 'resolved'
+WHERE provider = $1 AND event_id = $2 AND status = 'dead_letter';
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE (sqlc.narg('provider')::TEXT IS NULL OR provider = sqlc.narg('provider'))
+    AND (sqlc.narg('status')::TEXT IS NULL OR status = sqlc.narg('status'))
+    AND (sqlc.narg('event_type')::TEXT IS NULL OR event_type = sqlc.narg('event_type'))
+    AND (sqlc.narg('event_id')::TEXT IS NULL OR event_id = sqlc.narg('event_id'))
+    AND (sqlc.narg('since')::TIMESTAMP IS NULL OR received_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::TIMESTAMP IS NULL OR received_at < sqlc.narg('until'))
+ORDER BY received_at DESC
+LIMIT sqlc.arg('limit');
+
+-- name: PurgeWebhookDeliveries :execrows
+-- Deletes deliveries received before cutoff, except dead letters still
+-- waiting to be replayed.
+DELETE FROM webhook_deliveries
+WHERE received_at < @cutoff AND status <> 'dead_letter';
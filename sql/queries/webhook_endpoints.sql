@@ -0,0 +1,106 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, user_id, url, encrypted_secret, events)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UpdateWebhookEndpoint :one
+UPDATE webhook_endpoints
+SET url = $3, events = $4, updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: EnableWebhookEndpoint :exec
+UPDATE webhook_endpoints
+SET disabled_at = NULL, disabled_reason = '', consecutive_failures = 0, updated_at = NOW()
+WHERE id = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1 AND user_id = $2;
+
+-- name: RecordWebhookEndpointSuccess :exec
+UPDATE webhook_endpoints
+SET consecutive_failures = 0
+WHERE id = $1;
+
+-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints
+SET consecutive_failures = consecutive_failures + 1,
+    disabled_at = CASE
+        WHEN disabled_at IS NULL AND consecutive_failures + 1 >= sqlc.arg(max_failures)::INTEGER THEN NOW()
+        ELSE disabled_at
+    END,
+    disabled_reason = CASE
+        WHEN disabled_at IS NULL AND consecutive_failures + 1 >= sqlc.arg(max_failures)::INTEGER THEN 'too many failed deliveries'
+        ELSE disabled_reason
+    END
+WHERE id = sqlc.arg(id)
+RETURNING *;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Skips endpoints that already have the event, so one published twice is
+-- still delivered once.
+INSERT INTO outbound_webhook_deliveries (id, created_at, endpoint_id, event_id, event_type, payload, status, next_attempt_at)
+SELECT gen_random_uuid(), NOW(), e.id, sqlc.arg(event_id), sqlc.arg(event_type), sqlc.arg(payload), 'pending', NOW()
+FROM webhook_endpoints e
+WHERE e.user_id = sqlc.arg(user_id)
+    AND e.disabled_at IS NULL
+    AND sqlc.arg(event_type)::TEXT = ANY(e.events)
+ON CONFLICT (endpoint_id, event_id) DO NOTHING;
+
+-- name: EnqueueWebhookDelivery :one
+-- Returns no row if the endpoint already has the event.
+INSERT INTO outbound_webhook_deliveries (id, created_at, endpoint_id, event_id, event_type, payload, status, next_attempt_at)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, 'pending', NOW())
+ON CONFLICT (endpoint_id, event_id) DO NOTHING
+RETURNING *;
+
+-- name: ClaimWebhookDeliveries :many
+-- Pushes each claimed delivery's next attempt past the lease, so a server
+-- that dies mid-delivery leaves it to be retried by another.
+UPDATE outbound_webhook_deliveries
+SET next_attempt_at = sqlc.arg(lease_until)
+WHERE id IN (
+    SELECT d.id FROM outbound_webhook_deliveries d
+    JOIN webhook_endpoints e ON e.id = d.endpoint_id
+    WHERE d.status = 'pending'
+        AND d.next_attempt_at <= NOW()
+        AND e.disabled_at IS NULL
+    ORDER BY d.next_attempt_at
+    LIMIT sqlc.arg(max_deliveries)
+    FOR UPDATE OF d SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RecordWebhookDeliveryResult :exec
+UPDATE outbound_webhook_deliveries
+SET status = $2, attempts = attempts + 1, last_attempt_at = NOW(),
+    next_attempt_at = $3, response_status = $4, error = $5
+WHERE id = $1;
+
+-- name: ListEndpointWebhookDeliveries :many
+SELECT * FROM outbound_webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: GetWebhookEndpointByID :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
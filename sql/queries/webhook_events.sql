@@ -0,0 +1,4 @@
+-- name: RecordWebhookEvent :execrows
+INSERT INTO webhook_events (provider, event_id, received_at, event_type, payload)
+VALUES ($1, $2, NOW(), $3, $4)
+ON CONFLICT (provider, event_id) DO NOTHING;
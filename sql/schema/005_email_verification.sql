@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE email_verifications (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    email TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Accounts from before verification never proved their address. They may
+-- keep posting, but count as unverified everywhere else.
+CREATE TABLE legacy_accounts (
+    user_id UUID PRIMARY KEY,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO legacy_accounts (user_id) SELECT id FROM users;
+
+-- +goose Down
+DROP TABLE legacy_accounts;
+DROP TABLE email_verifications;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE password_resets (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE password_resets;
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN deletion_scheduled_at TIMESTAMP DEFAULT NULL;
+
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    file_path TEXT DEFAULT NULL,
+    error TEXT DEFAULT NULL,
+    expires_at TIMESTAMP DEFAULT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE data_exports;
+ALTER TABLE users DROP COLUMN deletion_scheduled_at;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE user_totp (
+    user_id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    encrypted_secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP DEFAULT NULL,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    code_hash TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_mfa_recovery_codes_user_id ON mfa_recovery_codes(user_id);
+
+-- +goose Down
+DROP TABLE mfa_recovery_codes;
+DROP TABLE user_totp;
@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP DEFAULT NULL;
+
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+
+-- +goose Down
+-- Hashes can't be turned back into tokens, so every session is revoked.
+DROP INDEX idx_refresh_tokens_family_id;
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
+UPDATE refresh_tokens SET revoked_at = NOW() WHERE revoked_at IS NULL;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN last_used_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+
+-- +goose Down
+DROP INDEX idx_refresh_tokens_user_id;
+ALTER TABLE refresh_tokens DROP COLUMN last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN ip_address;
+ALTER TABLE refresh_tokens DROP COLUMN user_agent;
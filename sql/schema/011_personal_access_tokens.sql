@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE personal_access_tokens (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
+
+-- +goose Down
+DROP TABLE personal_access_tokens;
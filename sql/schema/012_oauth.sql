@@ -0,0 +1,61 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    name TEXT NOT NULL,
+    secret_hash TEXT DEFAULT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    owner_id UUID NOT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (owner_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_oauth_clients_owner_id ON oauth_clients(owner_id);
+
+CREATE TABLE oauth_grants (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    scopes TEXT[] NOT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL,
+    client_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    CONSTRAINT fk_oauth_clients
+    FOREIGN KEY (client_id)
+    REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL,
+    redirect_uri TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    grant_id UUID NOT NULL,
+    CONSTRAINT fk_oauth_grants
+    FOREIGN KEY (grant_id)
+    REFERENCES oauth_grants(id) ON DELETE CASCADE
+);
+
+CREATE TABLE oauth_refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP DEFAULT NULL,
+    grant_id UUID NOT NULL,
+    CONSTRAINT fk_oauth_grants
+    FOREIGN KEY (grant_id)
+    REFERENCES oauth_grants(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE oauth_refresh_tokens;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_grants;
+DROP TABLE oauth_clients;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP DEFAULT NULL
+);
+
+-- For finding throttles with nothing left to enforce.
+CREATE INDEX idx_login_throttles_last_failed_at ON login_throttles(last_failed_at);
+
+-- +goose Down
+DROP TABLE login_throttles;
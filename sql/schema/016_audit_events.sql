@@ -0,0 +1,34 @@
+-- +goose Up
+-- No foreign keys: events have to outlive the users they mention.
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    action TEXT NOT NULL,
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure')),
+    actor_id UUID DEFAULT NULL,
+    user_id UUID DEFAULT NULL,
+    detail TEXT NOT NULL,
+    ip_address TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    request_id TEXT NOT NULL
+);
+
+CREATE INDEX idx_audit_events_created_at ON audit_events(created_at);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id, created_at);
+CREATE INDEX idx_audit_events_actor_id ON audit_events(actor_id, created_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only();
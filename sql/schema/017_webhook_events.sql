@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    provider TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    PRIMARY KEY (provider, event_id)
+);
+
+-- +goose Down
+DROP TABLE webhook_events;
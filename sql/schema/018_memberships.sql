@@ -0,0 +1,48 @@
+-- +goose Up
+-- One row per user who has ever been a Chirpy Red member. users.is_chirpy_red
+-- stays as the flag the rest of the app reads, kept in step with status.
+CREATE TABLE memberships (
+    user_id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    grace_until TIMESTAMP DEFAULT NULL,
+    canceled_at TIMESTAMP DEFAULT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_memberships_status ON memberships(status);
+
+CREATE TABLE membership_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    event TEXT NOT NULL,
+    source TEXT NOT NULL,
+    provider_event_id TEXT DEFAULT NULL,
+    status TEXT NOT NULL,
+    period_end TIMESTAMP DEFAULT NULL,
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_membership_events_user_id ON membership_events(user_id, created_at);
+
+-- Members from before periods were tracked get one from now.
+INSERT INTO memberships (user_id, created_at, updated_at, status, current_period_start, current_period_end)
+SELECT id, NOW(), NOW(), 'active', NOW(), NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+INSERT INTO membership_events (id, created_at, user_id, event, source, status, period_end)
+SELECT gen_random_uuid(), NOW(), user_id, 'migrated', 'system', status, current_period_end
+FROM memberships;
+
+-- +goose Down
+DROP TABLE membership_events;
+DROP TABLE memberships;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- Every inbound webhook request, whatever became of it. webhook_events
+-- still decides whether an event has already been processed.
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY,
+    provider TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    headers TEXT NOT NULL,
+    body TEXT NOT NULL,
+    event_id TEXT NOT NULL DEFAULT '',
+    event_type TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL CHECK (status IN ('pending', 'processed', 'duplicate', 'rejected', 'dead_letter', 'resolved')),
+    error TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX idx_webhook_deliveries_received_at ON webhook_deliveries(received_at);
+CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries(status, received_at);
+CREATE INDEX idx_webhook_deliveries_event ON webhook_deliveries(provider, event_id);
+
+INSERT INTO webhook_deliveries (id, provider, received_at, headers, body, event_id, event_type, status, attempts, last_attempt_at)
+SELECT gen_random_uuid(), provider, received_at, '{}', payload, event_id, event_type, 'processed', 1, received_at
+FROM webhook_events;
+
+-- +goose Down
+DROP TABLE webhook_deliveries;
@@ -0,0 +1,48 @@
+-- +goose Up
+CREATE TABLE webhook_endpoints (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    -- Sealed with auth.EncryptSecret; it's needed in the clear to sign.
+    encrypted_secret TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP DEFAULT NULL,
+    disabled_reason TEXT NOT NULL DEFAULT '',
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_webhook_endpoints_user_id ON webhook_endpoints(user_id);
+
+-- The delivery queue: one row per event per endpoint, worked through by
+-- every instance of the server.
+CREATE TABLE outbound_webhook_deliveries (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    endpoint_id UUID NOT NULL,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_attempt_at TIMESTAMP DEFAULT NULL,
+    response_status INTEGER DEFAULT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    CONSTRAINT fk_webhook_endpoints
+    FOREIGN KEY (endpoint_id)
+    REFERENCES webhook_endpoints(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_outbound_webhook_deliveries_due ON outbound_webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_outbound_webhook_deliveries_endpoint_id ON outbound_webhook_deliveries(endpoint_id, created_at);
+-- An event published twice must still reach each endpoint once.
+CREATE UNIQUE INDEX idx_outbound_webhook_deliveries_event ON outbound_webhook_deliveries(endpoint_id, event_id);
+
+-- +goose Down
+DROP TABLE outbound_webhook_deliveries;
+DROP TABLE webhook_endpoints;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Which Chirpy user each payment provider's customer is.
+CREATE TABLE payment_customers (
+    provider TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, customer_id),
+    CONSTRAINT fk_users
+    FOREIGN KEY (user_id)
+    REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_payment_customers_user_id ON payment_customers(user_id);
+
+-- +goose Down
+DROP TABLE payment_customers;
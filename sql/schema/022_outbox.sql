@@ -0,0 +1,25 @@
+-- +goose Up
+-- Messages about changes, written in the same transaction as the change and
+-- published afterwards by the outbox relay. id orders messages about the
+-- same aggregate.
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    aggregate_type TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    published_at TIMESTAMP DEFAULT NULL,
+    -- Set when a message has failed too often to keep retrying. It is kept,
+    -- with its last error, for looking into.
+    failed_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX idx_outbox_unpublished ON outbox(aggregate_type, aggregate_id, id) WHERE published_at IS NULL AND failed_at IS NULL;
+CREATE INDEX idx_outbox_published_at ON outbox(published_at) WHERE published_at IS NOT NULL;
+
+-- +goose Down
+DROP TABLE outbox;
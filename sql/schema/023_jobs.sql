@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE jobs (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    kind TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('queued', 'running', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    run_at TIMESTAMP NOT NULL,
+    -- A running job whose lease has run out was abandoned by a server that
+    -- died, and may be claimed again.
+    locked_until TIMESTAMP DEFAULT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    finished_at TIMESTAMP DEFAULT NULL,
+    -- Set for jobs that must only be queued once, such as each run of a
+    -- recurring job.
+    unique_key TEXT DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX idx_jobs_unique_key ON jobs(unique_key) WHERE unique_key IS NOT NULL;
+CREATE INDEX idx_jobs_queued ON jobs(run_at) WHERE status = 'queued';
+CREATE INDEX idx_jobs_running ON jobs(locked_until) WHERE status = 'running';
+CREATE INDEX idx_jobs_created_at ON jobs(created_at);
+CREATE INDEX idx_jobs_finished_at ON jobs(finished_at) WHERE finished_at IS NOT NULL;
+
+-- +goose Down
+DROP TABLE jobs;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- For finding expired and revoked refresh tokens to purge.
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX idx_refresh_tokens_revoked_at ON refresh_tokens(revoked_at) WHERE revoked_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_refresh_tokens_revoked_at;
+DROP INDEX idx_refresh_tokens_expires_at;
@@ -2,12 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"time"
 
-	"github.com/bdjekel/chirpy/internal/auth"
 	"github.com/bdjekel/chirpy/internal/database"
 	"github.com/google/uuid"
 )
@@ -34,7 +32,16 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address.", err)
+		return
+	}
+	if err := cfg.passwordPolicy.check(params.Password); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password not allowed: "+err.Error()+".", err)
+		return
+	}
+
+	hashedPassword, err := cfg.passwordParams.Hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
 		return
@@ -44,13 +51,15 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request)
 		HashedPassword: hashedPassword,
 		Email: params.Email, 
 	})
-	fmt.Printf(">>%s<<", err)	
 	if err != nil {
-		// fmt.Printf(">>%s<<", user)
 		respondWithError(w, http.StatusInternalServerError, "Error creating user", err)
 		return
 	}
 
+	// The account exists either way; the user can ask for a new email later.
+	if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+	}
 
 	respondWithJSON(w, 201, UserCreatedResponse{
 		ID:        		user.ID,
@@ -65,6 +74,7 @@ func (cfg *apiConfig) handlerUpdateCredentials (w http.ResponseWriter, r *http.R
 	type parameters struct {
 		NewEmail string `json:"email"`
 		NewPassword string `json:"password"`
+		RevokeOtherSessions bool `json:"revoke_other_sessions"`
 	}
 
 	type UserUpdatedResponse struct {
@@ -75,18 +85,13 @@ func (cfg *apiConfig) handlerUpdateCredentials (w http.ResponseWriter, r *http.R
 		IsChirpyRed bool		`json:"is_chirpy_red"`
 	}
 
-	// Validate JWT Access Token
-	access_token, err := auth.GetBearerToken(r.Header)
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeSessionOnly)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error retreiving access_token.", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(access_token, os.Getenv("SECRET"))
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error validating access_token.", err)
+		respondWithAuthError(w, err)
 		return
 	}
+	userID := caller.UserID
 
 	// Decode Request Payload
 	decoder := json.NewDecoder(r.Body)
@@ -97,8 +102,23 @@ func (cfg *apiConfig) handlerUpdateCredentials (w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if err := validateEmail(params.NewEmail); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid email address.", err)
+		return
+	}
+	if err := cfg.passwordPolicy.check(params.NewPassword); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Password not allowed: "+err.Error()+".", err)
+		return
+	}
+
+	previous, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+
 	// Hash New Password
-	hashedPassword, err := auth.HashPassword(params.NewPassword)
+	hashedPassword, err := cfg.passwordParams.Hash(params.NewPassword)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error hashing password", err)
 		return
@@ -115,6 +135,34 @@ func (cfg *apiConfig) handlerUpdateCredentials (w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Optionally sign out everywhere except the session making the change.
+	if params.RevokeOtherSessions {
+		err = cfg.DB.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+			UserID: userID,
+			FamilyID: caller.SessionID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error revoking sessions.", err)
+			return
+		}
+	}
+
+	detail := "password changed"
+	if user.Email != previous.Email {
+		detail = "password changed; email changed from " + previous.Email + " to " + user.Email
+	}
+	if params.RevokeOtherSessions {
+		detail += "; other sessions revoked"
+	}
+	cfg.audit(r, auditEvent{Action: auditCredentialsUpdate, Outcome: auditSuccess, ActorID: userID, UserID: userID, Detail: detail})
+
+	// A changed email has to be verified again.
+	if user.Email != previous.Email {
+		if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+			log.Printf("Error sending verification email to user %s: %s", user.ID, err)
+		}
+	}
+
 	// Encode Response Payload
 	respondWithJSON(w, 200, UserUpdatedResponse{
 		ID:        		user.ID,
@@ -124,46 +172,3 @@ func (cfg *apiConfig) handlerUpdateCredentials (w http.ResponseWriter, r *http.R
 		IsChirpyRed: 	user.IsChirpyRed,
 	})
 }
-
-func (cfg *apiConfig) handlerMembershipUpgrade(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Event string `json:"event"`
-		Data struct {
-			UserID uuid.UUID `json:"user_id"`
-		} `json:"data"`
-	}
-
-	// Validate API Key
-	apiKey, err := auth.GetAPIKey(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Error retreiving apiKey.", err)
-		return
-	}
-
-	if apiKey != os.Getenv("POLKA_KEY") {
-		respondWithJSON(w, http.StatusUnauthorized, "Invalid ApiKey")
-		return
-	}
-
-	// Decode request
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
-		return
-	}
-
-	//TODO: this endpoint should definitely have auth...
-
-	if params.Event != "user.upgraded" {
-		respondWithJSON(w, http.StatusNoContent, nil)
-	}
-
-	err = cfg.DB.UpgradeUserMembership(r.Context(), params.Data.UserID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "User Membership Upgrade Failed.", err)
-	}
-
-	respondWithJSON(w, http.StatusNoContent, nil)
-}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const emailVerificationExpiry = 24 * time.Hour
+
+// validateEmail accepts a bare address like "user@example.com" and nothing
+// else (no display names, no surrounding whitespace).
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if addr.Address != email {
+		return errors.New("email must be a bare address")
+	}
+	return nil
+}
+
+// sendVerificationEmail records a single-use verification and mails its token
+// to the user's current address.
+func (cfg *apiConfig) sendVerificationEmail(ctx context.Context, user database.User) error {
+	verification, err := cfg.DB.CreateEmailVerification(ctx, database.CreateEmailVerificationParams{
+		ID:        uuid.New(),
+		ExpiresAt: time.Now().UTC().Add(emailVerificationExpiry),
+		Email:     user.Email,
+		UserID:    user.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := auth.MakeEmailVerificationToken(verification.ID, user.ID, user.Email, cfg.secret, emailVerificationExpiry)
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer.Send(ctx, mailer.Message{
+		From:    cfg.mailFrom,
+		To:      []string{user.Email},
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Welcome to Chirpy!\n\nTo verify your email address, send the token below to POST /api/users/verify. It expires in %s.\n\n%s\n",
+			emailVerificationExpiry, token),
+	})
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	// Decode request
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Validate Verification Token
+	claims, err := auth.ValidateEmailVerificationToken(params.Token, cfg.secret)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token.", err)
+		return
+	}
+
+	verification, err := cfg.DB.GetEmailVerification(r.Context(), claims.ID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token.", err)
+		return
+	}
+	if verification.UserID != claims.UserID || verification.Email != claims.Email {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token.", nil)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), verification.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired verification token.", err)
+		return
+	}
+	if user.Email != verification.Email {
+		respondWithError(w, http.StatusBadRequest, "Email address has changed since this token was issued.", nil)
+		return
+	}
+
+	// Consume the token; a second use affects no rows.
+	used, err := cfg.DB.UseEmailVerification(r.Context(), verification.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error consuming verification token.", err)
+		return
+	}
+	if used == 0 {
+		respondWithError(w, http.StatusBadRequest, "Verification token has already been used.", nil)
+		return
+	}
+
+	err = cfg.DB.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    user.ID,
+		Email: verification.Email,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error verifying email.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, nil)
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	// Authenticate
+	caller, err := cfg.authenticate(r, scopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID := caller.UserID
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User does not exist.", err)
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(w, http.StatusConflict, "Email is already verified.", nil)
+		return
+	}
+
+	if err := cfg.sendVerificationEmail(r.Context(), user); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error sending verification email.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, nil)
+}
+
+// requireVerifiedEmail reports whether the user may perform actions gated on
+// a verified email, writing a 403 when they may not.
+func (cfg *apiConfig) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	if !cfg.emailVerificationRequired {
+		return true
+	}
+
+	user, err := cfg.DB.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusUnauthorized, "User does not exist.", err)
+		return false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
+		return false
+	}
+	if user.EmailVerifiedAt.Valid {
+		return true
+	}
+
+	// Accounts from before verification was required may carry on.
+	legacy, err := cfg.DB.IsLegacyAccount(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user.", err)
+		return false
+	}
+	if !legacy {
+		respondWithError(w, http.StatusForbidden, "Email address must be verified first.", nil)
+		return false
+	}
+	return true
+}
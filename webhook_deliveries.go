@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultWebhookDeliveryLimit = 100
+	maxWebhookDeliveryLimit     = 1000
+)
+
+type WebhookDeliveryResponse struct {
+	ID            uuid.UUID       `json:"id"`
+	Provider      string          `json:"provider"`
+	ReceivedAt    time.Time       `json:"received_at"`
+	EventID       string          `json:"event_id"`
+	EventType     string          `json:"event_type"`
+	Status        string          `json:"status"`
+	Error         string          `json:"error"`
+	Attempts      int32           `json:"attempts"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at,omitempty"`
+	Headers       json.RawMessage `json:"headers"`
+	Body          string          `json:"body"`
+}
+
+func webhookDeliveryResponse(delivery database.WebhookDelivery) WebhookDeliveryResponse {
+	resp := WebhookDeliveryResponse{
+		ID:         delivery.ID,
+		Provider:   delivery.Provider,
+		ReceivedAt: delivery.ReceivedAt,
+		EventID:    delivery.EventID,
+		EventType:  delivery.EventType,
+		Status:     delivery.Status,
+		Error:      delivery.Error,
+		Attempts:   delivery.Attempts,
+		Headers:    json.RawMessage(delivery.Headers),
+		Body:       delivery.Body,
+	}
+	if delivery.LastAttemptAt.Valid {
+		resp.LastAttemptAt = &delivery.LastAttemptAt.Time
+	}
+	return resp
+}
+
+// handlerListWebhookDeliveries lists inbound webhook requests, newest first.
+// It filters on provider, status, event_type, event_id and an RFC 3339
+// since/until range; status=dead_letter is the dead-letter queue.
+func (cfg *apiConfig) handlerListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := database.ListWebhookDeliveriesParams{Limit: defaultWebhookDeliveryLimit}
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWebhookDeliveryLimit {
+			respondWithError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxWebhookDeliveryLimit)+".", err)
+			return
+		}
+		params.Limit = int32(n)
+	}
+	for name, dst := range map[string]*sql.NullString{
+		"provider":   &params.Provider,
+		"status":     &params.Status,
+		"event_type": &params.EventType,
+		"event_id":   &params.EventID,
+	} {
+		if v := query.Get(name); v != "" {
+			*dst = sql.NullString{String: v, Valid: true}
+		}
+	}
+	for name, dst := range map[string]*sql.NullTime{"since": &params.Since, "until": &params.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp.", err)
+				return
+			}
+			*dst = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+
+	deliveries, err := cfg.DB.ListWebhookDeliveries(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook deliveries.", err)
+		return
+	}
+
+	resp := make([]WebhookDeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, webhookDeliveryResponse(delivery))
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerReplayWebhookDelivery processes a dead-lettered delivery again, once
+// whatever made it fail has been fixed. Its signature was checked when it
+// arrived, so it isn't checked again; by now it would have expired anyway.
+func (cfg *apiConfig) handlerReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID.", err)
+		return
+	}
+
+	delivery, err := cfg.DB.GetWebhookDelivery(r.Context(), deliveryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Webhook delivery does not exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook delivery.", err)
+		return
+	}
+	if delivery.Status != webhookDeadLetter {
+		respondWithError(w, http.StatusConflict, "Only dead-lettered deliveries can be replayed.", nil)
+		return
+	}
+	provider, ok := cfg.paymentProviders[delivery.Provider]
+	if !ok {
+		respondWithError(w, http.StatusConflict, "Deliveries from "+delivery.Provider+" can't be replayed; it isn't configured.", nil)
+		return
+	}
+
+	caller, _ := principalFromContext(r.Context())
+	status, replayErr := cfg.processPaymentDelivery(r, provider, delivery)
+	outcome := auditSuccess
+	if replayErr != nil {
+		outcome = auditFailure
+	}
+	cfg.audit(r, auditEvent{Action: auditWebhookReplay, Outcome: outcome, ActorID: caller.UserID, Detail: "delivery " + delivery.ID.String() + ": " + status})
+
+	delivery, err = cfg.DB.GetWebhookDelivery(r.Context(), deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving webhook delivery.", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookDeliveryResponse(delivery))
+}
+
+// purgeWebhookDeliveries deletes deliveries older than webhookRetention,
+// keeping dead letters until they have been dealt with. It runs as a
+// recurring job.
+func (cfg *apiConfig) purgeWebhookDeliveries(ctx context.Context, _ struct{}) error {
+	purged, err := cfg.DB.PurgeWebhookDeliveries(ctx, time.Now().UTC().Add(-cfg.webhookRetention))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d old webhook deliveries", purged)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bdjekel/chirpy/internal/auth"
+	"github.com/bdjekel/chirpy/internal/database"
+	"github.com/bdjekel/chirpy/internal/webhook"
+	"github.com/google/uuid"
+)
+
+// Events users can subscribe their webhook endpoints to.
+const (
+	webhookChirpCreated = "chirp.created"
+	webhookChirpDeleted = "chirp.deleted"
+	// webhookTest is only ever sent by "send test event", whatever the
+	// endpoint subscribes to.
+	webhookTest = "webhook.test"
+)
+
+var webhookEventTypes = []string{webhookChirpCreated, webhookChirpDeleted}
+
+// Outbound delivery statuses, as stored in outbound_webhook_deliveries.status.
+const (
+	outboundPending   = "pending"
+	outboundSucceeded = "succeeded"
+	outboundFailed    = "failed"
+)
+
+const (
+	chirpySignatureHeader = "Chirpy-Signature"
+	chirpyEventHeader     = "Chirpy-Event"
+	chirpyDeliveryHeader  = "Chirpy-Delivery"
+
+	webhookDeliveryTimeout = 10 * time.Second
+	// webhookDeliveryLease is how long a claimed delivery is left to its
+	// server before another may try it. It must outlast the timeout.
+	webhookDeliveryLease = time.Minute
+	webhookDeliveryBatch = 50
+	// maxWebhookAttempts gives an endpoint about a day, with backoff, to
+	// come back before a delivery is given up on.
+	maxWebhookAttempts = 12
+	// maxWebhookEndpointFailures failed attempts in a row, across all its
+	// deliveries, switch an endpoint off until its owner turns it back on.
+	maxWebhookEndpointFailures = 20
+	maxWebhookErrorLength      = 500
+)
+
+type webhookEnvelope struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+func newWebhookPayload(eventID uuid.UUID, eventType string, data any) (string, error) {
+	payload, err := json.Marshal(webhookEnvelope{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	return string(payload), err
+}
+
+// publishWebhookEvent queues an event for every enabled endpoint of userID
+// that subscribes to it.
+func (cfg *apiConfig) publishWebhookEvent(ctx context.Context, userID uuid.UUID, eventType string, eventID uuid.UUID, data any) error {
+	payload, err := newWebhookPayload(eventID, eventType, data)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.DB.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		UserID:    userID,
+	})
+	return err
+}
+
+// runWebhookDispatcher sends queued webhook deliveries. Several servers can
+// run it at once; each claims its own deliveries.
+func (cfg *apiConfig) runWebhookDispatcher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.dispatchWebhooks(ctx); err != nil {
+			log.Printf("Error dispatching webhooks: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchWebhooks sends every delivery that is due, a batch at a time.
+func (cfg *apiConfig) dispatchWebhooks(ctx context.Context) error {
+	for {
+		deliveries, err := cfg.DB.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+			LeaseUntil:    time.Now().UTC().Add(webhookDeliveryLease),
+			MaxDeliveries: webhookDeliveryBatch,
+		})
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cfg.attemptWebhookDelivery(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookDeliveryBatch {
+			return nil
+		}
+	}
+}
+
+// attemptWebhookDelivery sends a claimed delivery once and records how it
+// went, scheduling a retry if it failed.
+func (cfg *apiConfig) attemptWebhookDelivery(ctx context.Context, delivery database.OutboundWebhookDelivery) {
+	endpoint, err := cfg.DB.GetWebhookEndpointByID(ctx, delivery.EndpointID)
+	if err != nil {
+		log.Printf("Error retrieving webhook endpoint %s: %s", delivery.EndpointID, err)
+		return
+	}
+
+	statusCode, sendErr := cfg.sendWebhook(ctx, endpoint, delivery)
+
+	result := database.RecordWebhookDeliveryResultParams{
+		ID:             delivery.ID,
+		Status:         outboundSucceeded,
+		NextAttemptAt:  time.Now().UTC(),
+		ResponseStatus: sql.NullInt32{Int32: int32(statusCode), Valid: statusCode != 0},
+	}
+	if sendErr != nil {
+		attempts := int(delivery.Attempts) + 1
+		result.Error = sendErr.Error()
+		if len(result.Error) > maxWebhookErrorLength {
+			result.Error = result.Error[:maxWebhookErrorLength]
+		}
+		result.Status = outboundPending
+		result.NextAttemptAt = result.NextAttemptAt.Add(webhook.RetryDelay(attempts))
+		if attempts >= maxWebhookAttempts {
+			result.Status = outboundFailed
+		}
+	}
+	if err := cfg.DB.RecordWebhookDeliveryResult(ctx, result); err != nil {
+		log.Printf("Error recording webhook delivery %s: %s", delivery.ID, err)
+	}
+
+	if sendErr == nil {
+		if err := cfg.DB.RecordWebhookEndpointSuccess(ctx, endpoint.ID); err != nil {
+			log.Printf("Error recording success of webhook endpoint %s: %s", endpoint.ID, err)
+		}
+		return
+	}
+	updated, err := cfg.DB.RecordWebhookEndpointFailure(ctx, database.RecordWebhookEndpointFailureParams{
+		MaxFailures: maxWebhookEndpointFailures,
+		ID:          endpoint.ID,
+	})
+	if err != nil {
+		log.Printf("Error recording failure of webhook endpoint %s: %s", endpoint.ID, err)
+		return
+	}
+	if updated.DisabledAt.Valid && !endpoint.DisabledAt.Valid {
+		log.Printf("Disabled webhook endpoint %s of user %s after %d failed deliveries", endpoint.ID, endpoint.UserID, updated.ConsecutiveFailures)
+	}
+}
+
+// sendWebhook posts a delivery to its endpoint. Anything but a 2xx response
+// is an error.
+func (cfg *apiConfig) sendWebhook(ctx context.Context, endpoint database.WebhookEndpoint, delivery database.OutboundWebhookDelivery) (int, error) {
+	secret, err := auth.DecryptSecret(endpoint.EncryptedSecret, cfg.secret)
+	if err != nil {
+		return 0, fmt.Errorf("decrypting endpoint secret: %w", err)
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(chirpySignatureHeader, webhook.Sign(secret, time.Now(), body))
+	req.Header.Set(chirpyEventHeader, delivery.EventType)
+	req.Header.Set(chirpyDeliveryHeader, delivery.ID.String())
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}